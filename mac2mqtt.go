@@ -3,29 +3,40 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	_ "net/http/pprof"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/shirou/gopsutil/v3/mem" // Using v3 for current versions
+	psnet "github.com/shirou/gopsutil/v3/net"
+	"github.com/shirou/gopsutil/v3/process"
 	"gopkg.in/yaml.v2"
 
 	sigar "github.com/cloudfoundry/gosigar"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"golang.org/x/net/dns/dnsmessage"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
@@ -47,6 +58,18 @@ const (
 	MaxBrightness          = 100
 	MinBrightness          = 0
 	MaxRetryAttempts       = 1
+	VolumeWatchInterval    = 5 * time.Second
+	DiskUsageTimeout       = 5 * time.Second // a dead network server can block statfs for minutes
+	MinVolumeFadeInterval  = 250 * time.Millisecond
+	AnnouncementQueueSize  = 16
+	AnnouncementTimeout    = 5 * time.Minute
+	SystemSoundsDir        = "/System/Library/Sounds"
+	ShortcutTimeout        = 10 * time.Minute
+	NotificationTimeout    = 24 * time.Hour // longest an alerter notification waits for a response
+	MaxCommandOutput       = 64 * 1024
+	WiFiDetailsInterval    = 5 * time.Minute
+	ScheduleFileName       = "mac2mqtt_schedule.json"
+	ScheduledActionGrace   = 5 * time.Minute // how late a scheduled action may still run after a restart
 )
 
 // BetterDisplayCLIError represents an error when BetterDisplay CLI is not available
@@ -111,31 +134,255 @@ type Display struct {
 
 // Application holds the main application state
 type Application struct {
-	config            *config
-	displays          []Display
-	hostname          string
-	topic             string
-	client            mqtt.Client
-	currentMediaState MediaInfo // persistent media state for streaming
-	userActivityState string    // "active" or "inactive"
-	activityMutex     sync.RWMutex
-	activityTimer     *time.Timer
-	activityCtx       context.Context    // Context for cancelling activity monitoring
-	activityCancel    context.CancelFunc // Cancel function for activity monitoring
-	lastCPU           sigar.Cpu          // for CPU percentage calculation
-	cpuMutex          sync.RWMutex
+	config                *config
+	displays              []Display
+	hostname              string
+	topic                 string
+	client                mqtt.Client
+	currentMediaState     MediaInfo // persistent media state for streaming
+	mediaMutex            sync.RWMutex
+	userActivityState     string // "active" or "inactive"
+	activityMutex         sync.RWMutex
+	activityTimer         *time.Timer
+	activityCtx           context.Context    // Context for cancelling activity monitoring
+	activityCancel        context.CancelFunc // Cancel function for activity monitoring
+	lastCPU               sigar.Cpu          // for CPU percentage calculation
+	lastCPUList           sigar.CpuList      // for per-core CPU percentage calculation
+	cpuTopology           CPUTopology
+	thermalBackend        ThermalBackend // nil when thermal sensors are disabled
+	thermalInfo           *ThermalInfo   // last reading, used to build discovery
+	thermalMutex          sync.RWMutex
+	audioBackend          AudioBackend
+	audioErrors           map[string]string // last logged error of each periodic audio query
+	audioErrorsMutex      sync.Mutex
+	audioDevicesAvailable bool          // SwitchAudioSource is installed
+	audioDevices          *AudioDevices // last device list, for change events
+	inputVolumeBeforeMute int           // restored when the microphone is unmuted
+	audioMutex            sync.Mutex
+	volumeFadeCancel      context.CancelFunc // stops the running volume fade
+	volumeBeforeDuck      *int               // set while the volume is ducked
+	duckTimer             *time.Timer        // restores the volume after a timed duck
+	volumeFadeMutex       sync.Mutex
+	announcements         chan *Announcement // queued say and sound commands
+	shortcuts             []string           // shortcuts of the current user, for the select options
+	selectedShortcut      string             // run by the Run Shortcut button
+	shortcutMutex         sync.Mutex
+	scheduledAction       *ScheduledAction // pending /command/set action, nil when there is none
+	scheduleTimer         *time.Timer
+	scheduleFile          string // where scheduledAction is saved
+	scheduleMutex         sync.Mutex
+	pendingConfirmations  map[string]*PendingConfirmation // nonce -> armed destructive command
+	confirmMutex          sync.Mutex
+	keepAwakeCmd          *exec.Cmd         // caffeinate started by mac2mqtt, nil when not keeping awake
+	keepAwakeRequest      *KeepAwakeRequest // what keepAwakeCmd was started for
+	keepAwakeStarted      time.Time
+	keepAwakeMutex        sync.Mutex
+	lastProcCPU           map[int32]float64 // pid -> CPU seconds, for per-process CPU percentage calculation
+	lastProcTime          time.Time
+	cpuMutex              sync.RWMutex
+	alertStates           map[string]bool // alert name -> problem state, missing until first evaluation
+	alertMutex            sync.Mutex
+	diskVolumes           []VolumeUsage // mounted volumes matching the disks config
+	removedDiskSlugs      []string      // volumes to remove from discovery on the next setDevice
+	diskMutex             sync.RWMutex
+	mountedVolumes        map[string]VolumeUsage          // mount point -> volume, for mount/unmount events
+	lastNetIO             map[string]psnet.IOCountersStat // for network throughput calculation
+	lastNetIOTime         time.Time
+	netMutex              sync.Mutex
+	wifiDetails           WiFiInfo // channel and signal from system_profiler, for wifiDetails.SSID
+	wifiDetailsTime       time.Time
+	wifiMutex             sync.Mutex
+	publicIPProviders     []PublicIPProvider
+	publicIPv4            publicIPCache
+	publicIPv6            publicIPCache
 }
 
 type config struct {
-	IP               string `yaml:"mqtt_ip"`
-	Port             string `yaml:"mqtt_port"`
-	User             string `yaml:"mqtt_user"`
-	Password         string `yaml:"mqtt_password"`
-	SSL              bool   `yaml:"mqtt_ssl"`
-	Hostname         string `yaml:"hostname"`
-	Topic            string `yaml:"mqtt_topic"`
-	DiscoveryPrefix  string `yaml:"discovery_prefix"`
-	IdleActivityTime int    `yaml:"idle_activity_time"` // in seconds
+	IP               string           `yaml:"mqtt_ip"`
+	Port             string           `yaml:"mqtt_port"`
+	User             string           `yaml:"mqtt_user"`
+	Password         string           `yaml:"mqtt_password"`
+	SSL              bool             `yaml:"mqtt_ssl"`
+	Hostname         string           `yaml:"hostname"`
+	Topic            string           `yaml:"mqtt_topic"`
+	DiscoveryPrefix  string           `yaml:"discovery_prefix"`
+	IdleActivityTime int              `yaml:"idle_activity_time"` // in seconds
+	KeepAwakeMode    string           `yaml:"keep_awake_mode"`    // display, idle or system, default display
+	Alerts           []AlertRule      `yaml:"alerts"`
+	Disks            DiskConfig       `yaml:"disks"`
+	Network          NetworkConfig    `yaml:"network"`
+	PublicIP         PublicIPConfig   `yaml:"public_ip"`
+	CPU              CPUConfig        `yaml:"cpu"`
+	Processes        ProcessConfig    `yaml:"processes"`
+	Apps             []AppConfig      `yaml:"apps"`
+	Thermal          ThermalConfig    `yaml:"thermal"`
+	Audio            AudioConfig      `yaml:"audio"`
+	Notifications    NotifyConfig     `yaml:"notifications"`
+	Confirm          ConfirmConfig    `yaml:"confirm"`
+	PowerGuard       PowerGuardConfig `yaml:"power_guard"`
+	Open             OpenConfig       `yaml:"open"`
+	Commands         []CustomCommand  `yaml:"commands"`
+	Sensors          []CustomSensor   `yaml:"sensors"`
+}
+
+// CustomSensor is a sensor from the config whose value comes from a command, an AppleScript or a file
+type CustomSensor struct {
+	Name        string   `yaml:"name"`
+	Argv        []string `yaml:"argv"`        // program and arguments
+	AppleScript string   `yaml:"applescript"` // run with osascript instead of argv
+	File        string   `yaml:"file"`        // read instead of running a command
+	Interval    int      `yaml:"interval"`    // seconds, default 60
+	Timeout     int      `yaml:"timeout"`     // seconds, default 10
+	Regex       string   `yaml:"regex"`       // extracts the first capture group, or the whole match
+	JSONPath    string   `yaml:"json_path"`   // extracts a value from JSON output, e.g. "containers.0.name"
+	Type        string   `yaml:"type"`        // string, int, float or bool (a binary sensor), default string
+	Unit        string   `yaml:"unit"`
+	DeviceClass string   `yaml:"device_class"`
+	StateClass  string   `yaml:"state_class"`
+	Icon        string   `yaml:"icon"`
+	re          *regexp.Regexp
+}
+
+// CustomCommand is a named command from the config, run through /command/custom/<name>
+type CustomCommand struct {
+	Name        string        `yaml:"name"`
+	Argv        []string      `yaml:"argv"`        // program and arguments, {{param}} placeholders are filled in
+	AppleScript string        `yaml:"applescript"` // run with osascript instead of argv, placeholders become quoted strings
+	Timeout     int           `yaml:"timeout"`     // seconds, default 60
+	RunAs       string        `yaml:"run_as"`      // user to run as with sudo -n
+	Dangerous   bool          `yaml:"dangerous"`   // has to be confirmed like shutdown
+	Params      []CustomParam `yaml:"params"`
+}
+
+// CustomParam is a parameter a custom command accepts from the MQTT payload
+type CustomParam struct {
+	Name     string `yaml:"name"`
+	Pattern  string `yaml:"pattern"`  // regular expression the whole value must match, default [A-Za-z0-9_.][A-Za-z0-9_.-]*
+	Default  string `yaml:"default"`  // used when the payload does not set the parameter
+	Optional bool   `yaml:"optional"` // allow an empty value when there is no default
+	re       *regexp.Regexp
+}
+
+// OpenConfig limits what the open command may open
+type OpenConfig struct {
+	Schemes []string `yaml:"schemes"` // allowed URL schemes, default http and https
+	Roots   []string `yaml:"roots"`   // folders whose files may be opened, none by default
+}
+
+// ConfirmConfig configures the confirmation of destructive commands
+type ConfirmConfig struct {
+	Timeout       int  `yaml:"timeout"`        // seconds an armed command waits for its nonce, default 60
+	Dialog        bool `yaml:"dialog"`         // show a dialog on the Mac that can cancel a confirmed command
+	DialogTimeout int  `yaml:"dialog_timeout"` // seconds the dialog waits before the command runs, default 30
+}
+
+// PowerGuardConfig holds back sleep, shutdown, restart and logout while the Mac is in use
+type PowerGuardConfig struct {
+	Mode     string `yaml:"mode"`      // off, refuse or defer, default off
+	Retry    int    `yaml:"retry"`     // seconds between checks while deferring, default 60
+	MaxDefer int    `yaml:"max_defer"` // seconds to keep deferring before refusing, default 3600
+}
+
+// NotifyConfig configures macOS notifications
+type NotifyConfig struct {
+	AlerterPath string `yaml:"alerter_path"` // alerter adds action buttons, default /opt/homebrew/bin/alerter
+}
+
+// AudioConfig selects how the output volume and mute state are controlled
+type AudioConfig struct {
+	Backend               string `yaml:"backend"`                // auto, osascript, betterdisplay or switchaudiosource, default auto
+	BetterDisplayURL      string `yaml:"betterdisplay_url"`      // default http://localhost:55777
+	SwitchAudioSourcePath string `yaml:"switchaudiosource_path"` // default /opt/homebrew/bin/SwitchAudioSource
+	Timeout               int    `yaml:"timeout"`                // seconds, default 5
+	VolumeStep            int    `yaml:"volume_step"`            // volume up/down step, default 5
+	DuckVolume            int    `yaml:"duck_volume"`            // volume while ducked, default 20
+}
+
+// ThermalConfig selects how temperatures and fan speeds are read
+type ThermalConfig struct {
+	Backend string `yaml:"backend"`  // powermetrics, smc or none, default picks one automatically
+	SMCPath string `yaml:"smc_path"` // path to the smc tool, default /usr/local/bin/smc
+}
+
+// AppConfig declares a watched application and the commands allowed for it
+type AppConfig struct {
+	Name     string   `yaml:"name"`     // application name as used by `open -a`, e.g. "Slack"
+	Process  string   `yaml:"process"`  // process name for the running check, defaults to name
+	Commands []string `yaml:"commands"` // allowed commands: launch, quit, kill; default launch and quit
+}
+
+// Slug returns the topic and unique_id safe name of the application
+func (a AppConfig) Slug() string {
+	slug := regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(strings.ToLower(a.Name), "_")
+	return strings.Trim(slug, "_")
+}
+
+// Allows reports whether the command is in the allowlist of the application
+func (a AppConfig) Allows(command string) bool {
+	for _, allowed := range a.Commands {
+		if allowed == command {
+			return true
+		}
+	}
+	return false
+}
+
+// ProcessConfig enables the top processes sensors
+type ProcessConfig struct {
+	Enabled  bool `yaml:"enabled"`
+	Count    int  `yaml:"count"`    // number of processes to publish, default 5
+	Interval int  `yaml:"interval"` // seconds between samples, default 30
+}
+
+// CPUConfig enables optional CPU sensors
+type CPUConfig struct {
+	PerCore bool `yaml:"per_core"` // publish the usage of every logical core
+}
+
+// PublicIPConfig selects how the public IP address is looked up
+type PublicIPConfig struct {
+	Providers []PublicIPProviderConfig `yaml:"providers"` // tried in order until one answers
+	IPv6      bool                     `yaml:"ipv6"`      // also look up the public IPv6 address
+	CacheTTL  int                      `yaml:"cache_ttl"` // in seconds
+}
+
+// PublicIPProviderConfig describes one public IP provider
+type PublicIPProviderConfig struct {
+	Type       string `yaml:"type"`        // google, cloudflare, opendns, https or stun
+	Server     string `yaml:"server"`      // overrides the DNS server, HTTPS URL or STUN server
+	ServerIPv6 string `yaml:"server_ipv6"` // overrides the DNS server or HTTPS URL used for IPv6
+}
+
+// NetworkConfig configures network throughput sensors and connectivity probes
+type NetworkConfig struct {
+	Interfaces []string      `yaml:"interfaces"` // interface patterns for throughput sensors, e.g. en*
+	Probes     []ProbeConfig `yaml:"probes"`
+}
+
+// ProbeConfig describes a latency and packet loss probe
+type ProbeConfig struct {
+	Name     string `yaml:"name"`
+	Type     string `yaml:"type"`     // "ping" or "tcp"
+	Target   string `yaml:"target"`   // host name or address, "gateway" or "broker"
+	Port     string `yaml:"port"`     // tcp only, defaults to the MQTT port for the broker
+	Count    int    `yaml:"count"`    // packets or connection attempts per run
+	Interval int    `yaml:"interval"` // in seconds
+	Timeout  int    `yaml:"timeout"`  // in seconds, per packet or connection
+}
+
+// DiskConfig selects which mounted volumes get their own disk usage sensors
+type DiskConfig struct {
+	Include []string `yaml:"include"` // mount point patterns, e.g. /Volumes/*
+	Exclude []string `yaml:"exclude"`
+}
+
+// AlertRule describes a threshold alert over one of the published sensors
+type AlertRule struct {
+	Name       string   `yaml:"name"`
+	Sensor     string   `yaml:"sensor"`     // battery, disk, cpu or memory
+	Above      *float64 `yaml:"above"`      // problem when the value rises above this
+	Below      *float64 `yaml:"below"`      // problem when the value drops below this
+	Hysteresis float64  `yaml:"hysteresis"` // distance back past the threshold required to clear
 }
 
 func (c *config) getConfig() *config {
@@ -176,6 +423,110 @@ func (c *config) getConfig() *config {
 	if c.DiscoveryPrefix == "" {
 		c.DiscoveryPrefix = "homeassistant"
 	}
+	if len(c.Disks.Include) == 0 {
+		c.Disks.Include = []string{"/", "/System/Volumes/Data", "/Volumes/*"}
+	}
+	if len(c.Network.Interfaces) == 0 {
+		c.Network.Interfaces = []string{"en*"}
+	}
+	if len(c.PublicIP.Providers) == 0 {
+		c.PublicIP.Providers = []PublicIPProviderConfig{{Type: "google"}, {Type: "cloudflare"}, {Type: "opendns"}}
+	}
+	if c.PublicIP.CacheTTL == 0 {
+		c.PublicIP.CacheTTL = 300
+	}
+	if c.Audio.Backend == "" {
+		c.Audio.Backend = "auto"
+	}
+	if c.Audio.BetterDisplayURL == "" {
+		c.Audio.BetterDisplayURL = "http://localhost:55777"
+	}
+	if c.Audio.SwitchAudioSourcePath == "" {
+		c.Audio.SwitchAudioSourcePath = "/opt/homebrew/bin/SwitchAudioSource"
+	}
+	if c.Audio.Timeout == 0 {
+		c.Audio.Timeout = 5
+	}
+	if c.Audio.VolumeStep == 0 {
+		c.Audio.VolumeStep = 5
+	}
+	if c.Audio.DuckVolume == 0 {
+		c.Audio.DuckVolume = 20
+	}
+	for i := range c.Commands {
+		if c.Commands[i].Timeout == 0 {
+			c.Commands[i].Timeout = 60
+		}
+	}
+	for i := range c.Sensors {
+		sensor := &c.Sensors[i]
+		if sensor.Interval == 0 {
+			sensor.Interval = 60
+		}
+		if sensor.Timeout == 0 {
+			sensor.Timeout = 10
+		}
+		if sensor.Type == "" {
+			sensor.Type = "string"
+		}
+	}
+	if len(c.Open.Schemes) == 0 {
+		c.Open.Schemes = []string{"http", "https"}
+	}
+	if c.KeepAwakeMode == "" {
+		c.KeepAwakeMode = "display"
+	}
+	if c.Confirm.Timeout == 0 {
+		c.Confirm.Timeout = 60
+	}
+	if c.Confirm.DialogTimeout == 0 {
+		c.Confirm.DialogTimeout = 30
+	}
+	if c.PowerGuard.Mode == "" {
+		c.PowerGuard.Mode = "off"
+	}
+	if c.PowerGuard.Retry == 0 {
+		c.PowerGuard.Retry = 60
+	}
+	if c.PowerGuard.MaxDefer == 0 {
+		c.PowerGuard.MaxDefer = 3600
+	}
+	if c.Notifications.AlerterPath == "" {
+		c.Notifications.AlerterPath = "/opt/homebrew/bin/alerter"
+	}
+	if c.Thermal.SMCPath == "" {
+		c.Thermal.SMCPath = "/usr/local/bin/smc"
+	}
+	if c.Processes.Count == 0 {
+		c.Processes.Count = 5
+	}
+	if c.Processes.Interval == 0 {
+		c.Processes.Interval = 30
+	}
+	for i := range c.Apps {
+		watched := &c.Apps[i]
+		if watched.Process == "" {
+			watched.Process = watched.Name
+		}
+		if watched.Commands == nil {
+			watched.Commands = []string{"launch", "quit"}
+		}
+	}
+	for i := range c.Network.Probes {
+		probe := &c.Network.Probes[i]
+		if probe.Type == "" {
+			probe.Type = "ping"
+		}
+		if probe.Count == 0 {
+			probe.Count = 4
+		}
+		if probe.Interval == 0 {
+			probe.Interval = 60
+		}
+		if probe.Timeout == 0 {
+			probe.Timeout = 5
+		}
+	}
 	return c
 }
 
@@ -207,28 +558,95 @@ func NewApplication() (*Application, error) {
 		return nil, fmt.Errorf("configuration validation failed: %w", err)
 	}
 
+	// Initialize public IP providers
+	for _, providerConfig := range app.config.PublicIP.Providers {
+		provider, err := newPublicIPProvider(providerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("configuration validation failed: %w", err)
+		}
+		app.publicIPProviders = append(app.publicIPProviders, provider)
+	}
+
+	// Initialize thermal backend
+	thermalBackend, err := newThermalBackend(app.config.Thermal)
+	if err != nil {
+		return nil, fmt.Errorf("configuration validation failed: %w", err)
+	}
+	app.thermalBackend = thermalBackend
+
+	// Initialize audio backend
+	audioBackend, err := newAudioBackend(app.config.Audio)
+	if err != nil {
+		return nil, fmt.Errorf("configuration validation failed: %w", err)
+	}
+	app.audioBackend = audioBackend
+
+	// Audio device selection needs SwitchAudioSource
+	app.audioDevicesAvailable = isSwitchAudioSourceAvailable(app.config.Audio.SwitchAudioSourcePath)
+	if !app.audioDevicesAvailable {
+		log.Println("SwitchAudioSource is not installed, audio device selection will be disabled")
+	}
+
+	app.announcements = make(chan *Announcement, AnnouncementQueueSize)
+	app.pendingConfirmations = make(map[string]*PendingConfirmation)
+
+	// Scheduled system actions are saved next to the executable to survive restarts
+	ex, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the executable: %w", err)
+	}
+	app.scheduleFile = filepath.Join(filepath.Dir(ex), ScheduleFileName)
+
 	// Initialize displays
 	app.displays = getDisplays()
 
 	// Initialize currentMediaState
+	media := MediaInfo{State: "idle"}
 	if isMediaControlAvailable() {
-		mediaInfo, err := getMediaInfo()
-		if err == nil && mediaInfo != nil {
-			app.currentMediaState = *mediaInfo
-		} else {
-			app.currentMediaState = MediaInfo{State: "idle"}
+		if mediaInfo, err := getMediaInfo(); err == nil && mediaInfo != nil {
+			media = *mediaInfo
 		}
-	} else {
-		app.currentMediaState = MediaInfo{State: "idle"}
 	}
+	app.mediaMutex.Lock()
+	app.currentMediaState = media
+	app.mediaMutex.Unlock()
 
 	// Initialize user activity state
 	app.userActivityState = "inactive"
 
+	app.alertStates = make(map[string]bool)
+
+	// Initialize mounted volumes
+	volumes, err := getVolumeUsages(app.config.Disks)
+	if err != nil {
+		log.Printf("Warning: Failed to list mounted volumes: %v", err)
+	}
+	app.diskVolumes = volumes
+
+	app.mountedVolumes = make(map[string]VolumeUsage)
+	mounted, err := listMountedVolumes(app.config.Disks)
+	if err != nil {
+		log.Printf("Warning: Failed to list mounted volumes: %v", err)
+	}
+	for _, volume := range mounted {
+		app.mountedVolumes[volume.Mount] = volume
+	}
+
 	// Initialize CPU stats for percentage calculation
 	if err := app.lastCPU.Get(); err != nil {
 		log.Printf("Warning: Failed to initialize CPU stats: %v", err)
 	}
+	if err := app.lastCPUList.Get(); err != nil {
+		log.Printf("Warning: Failed to initialize per-core CPU stats: %v", err)
+	}
+	app.cpuTopology = getCPUTopology()
+
+	// Initialize network counters for throughput calculation
+	app.lastNetIO, err = getNetIOCounters(app.config.Network.Interfaces)
+	if err != nil {
+		log.Printf("Warning: Failed to initialize network counters: %v", err)
+	}
+	app.lastNetIOTime = time.Now()
 
 	return app, nil
 }
@@ -244,6 +662,43 @@ func (app *Application) validateConfig() error {
 	if app.config.DiscoveryPrefix == "" {
 		app.config.DiscoveryPrefix = DefaultDiscoveryPrefix
 	}
+	if err := app.validateAlertRules(); err != nil {
+		return err
+	}
+	if err := app.validateProbes(); err != nil {
+		return err
+	}
+	if app.config.Processes.Count < 1 || app.config.Processes.Interval < 1 {
+		return fmt.Errorf("processes count and interval must be positive")
+	}
+	if err := app.validateApps(); err != nil {
+		return err
+	}
+	if err := app.validateCustomCommands(); err != nil {
+		return err
+	}
+	if err := app.validateCustomSensors(); err != nil {
+		return err
+	}
+	switch app.config.PowerGuard.Mode {
+	case "off", "refuse", "defer":
+	default:
+		return fmt.Errorf("power_guard mode must be off, refuse or defer, got %q", app.config.PowerGuard.Mode)
+	}
+	if _, ok := keepAwakeModes[app.config.KeepAwakeMode]; !ok {
+		return fmt.Errorf("keep_awake_mode must be display, idle or system, got %q", app.config.KeepAwakeMode)
+	}
+	if app.config.Confirm.Timeout < 1 || app.config.Confirm.DialogTimeout < 1 {
+		return fmt.Errorf("confirm timeout and dialog_timeout must be positive")
+	}
+	// root has no GUI session to show the dialog in, so every confirmed command would be cancelled
+	if app.config.Confirm.Dialog && os.Getuid() == 0 {
+		return fmt.Errorf("confirm dialog needs mac2mqtt to run as the logged in user, not as root")
+	}
+	// A retry in the past would check a deferred action again in a tight loop
+	if app.config.PowerGuard.Retry < 1 || app.config.PowerGuard.MaxDefer < 0 {
+		return fmt.Errorf("power_guard retry must be positive and max_defer must not be negative")
+	}
 	return nil
 }
 
@@ -315,1495 +770,5591 @@ func getWorkingDirectory() string {
 	return wd
 }
 
-func getCommandOutput(name string, arg ...string) string {
+func runCommand(name string, arg ...string) {
 	cmd := exec.Command(name, arg...)
-	stdout, err := cmd.Output()
+
+	_, err := cmd.Output()
 	if err != nil {
-		log.Println("error: " + err.Error())
-		log.Println("output: " + string(stdout))
 		log.Fatal(err)
 	}
-	stdoutStr := string(stdout)
-	stdoutStr = strings.TrimSuffix(stdoutStr, "\n")
+}
 
-	return stdoutStr
+// errAudioUnsupported is returned by audio backends that cannot control the current output device
+var errAudioUnsupported = errors.New("not supported for the current output device")
+
+// errAudioUnavailable is returned by audio backends whose service cannot be reached, e.g. BetterDisplay is not running
+var errAudioUnavailable = errors.New("audio backend unavailable")
+
+// AudioBackend reads and changes the output volume and mute state
+type AudioBackend interface {
+	Name() string
+	GetVolume() (int, error) // from 0 to 100
+	SetVolume(volume int) error
+	GetMute() (bool, error)
+	SetMute(mute bool) error
 }
 
-func getCaffeinateStatus() bool {
-	cmd := "/bin/ps ax | /usr/bin/grep caffeinate | /usr/bin/grep -v grep"
-	output, err := exec.Command("/bin/sh", "-c", cmd).Output()
-	//revive:disable-next-line
+// runAudioCommand runs a command with the audio timeout and returns its trimmed output
+func runAudioCommand(timeout time.Duration, name string, arg ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, name, arg...).CombinedOutput()
 	if err != nil {
-		//log.Fatal(err)
+		return "", fmt.Errorf("%s failed: %v: %s", filepath.Base(name), err, strings.TrimSpace(string(output)))
 	}
-	stdoutStr := string(output)
-	stdoutStr = strings.TrimSuffix(stdoutStr, "\n")
-	return stdoutStr != ""
+	return strings.TrimSpace(string(output)), nil
 }
 
-func getMuteStatus() bool {
-	log.Println("Getting mute status")
-	output := getCommandOutput("/usr/bin/osascript", "-e", "output muted of (get volume settings)")
-	b, err := strconv.ParseBool(output)
-	//revive:disable-next-line
+// osascriptAudioBackend uses the AppleScript volume settings, which only work for devices with a software volume
+type osascriptAudioBackend struct {
+	timeout time.Duration
+}
+
+func (b *osascriptAudioBackend) Name() string { return "osascript" }
+
+// getSetting returns a field of the volume settings, "missing value" means the device has no software volume
+func (b *osascriptAudioBackend) getSetting(field string) (string, error) {
+	output, err := runAudioCommand(b.timeout, "/usr/bin/osascript", "-e", field+" of (get volume settings)")
 	if err != nil {
-		// Continue to fallback method
+		return "", err
 	}
 	if output == "missing value" {
-		currentsource := getCommandOutput("/opt/homebrew/bin/switchaudiosource", "-c")
-		var resp *http.Response
-		var err error
-
-		// URL encode the current source name to handle spaces and special characters
-		encodedSource := strings.ReplaceAll(currentsource, " ", "%20")
-		url := fmt.Sprintf("http://localhost:55777/get?name=%s&mute", encodedSource)
-		resp, err = http.Get(url)
-		if err != nil {
-			log.Printf("Error getting mute status for %s: %v", currentsource, err)
-			return false
-		}
-		if resp != nil {
-			defer resp.Body.Close()
-			output, err := io.ReadAll(resp.Body)
-			if err != nil {
-				log.Printf("Error getting mute status body for %s: %v", currentsource, err)
-				return false
-			}
-			output = []byte(strings.TrimSuffix(string(output), "\n"))
-			mute := string(output)
-			log.Println("Mute Output: " + mute)
-			b = mute == "on"
-		}
+		return "", errAudioUnsupported
 	}
-	return b
+	return output, nil
 }
 
-func getCurrentVolume() int {
-	log.Println("Getting volume status")
-	output := getCommandOutput("/usr/bin/osascript", "-e", "output volume of (get volume settings)")
-	output = strings.TrimSuffix(output, "\n")
-	i, err := strconv.Atoi(output)
+func (b *osascriptAudioBackend) GetVolume() (int, error) {
+	output, err := b.getSetting("output volume")
 	if err != nil {
-		currentsource := getCommandOutput("/opt/homebrew/bin/switchaudiosource", "-c")
-		var resp *http.Response
-		var err error
-		// URL encode the current source name to handle spaces and special characters
-		encodedSource := strings.ReplaceAll(currentsource, " ", "%20")
-		url := fmt.Sprintf("http://localhost:55777/get?name=%s&volume", encodedSource)
-		resp, err = http.Get(url)
-		if err != nil {
-			log.Printf("Error getting volume status for %s: %v", currentsource, err)
-			return 0
-		}
-		if resp != nil {
-			defer resp.Body.Close()
-			output, err := io.ReadAll(resp.Body)
-			if err != nil {
-				log.Printf("Error getting volume status body for %s: %v", currentsource, err)
-				return 0
-			}
-			output = []byte(strings.TrimSuffix(string(output), "\n"))
-			outputStr := string(output)
-			log.Println("Vol Output: " + outputStr)
-			f, err := strconv.ParseFloat(outputStr, 64)
-			if err != nil {
-				log.Printf("Error parsing volume value for %s: %v", currentsource, err)
-				return 0
-			}
-			i = int(f * 100)
-		}
+		return 0, err
 	}
-	return i
+	return strconv.Atoi(output)
 }
 
-func runCommand(name string, arg ...string) {
-	cmd := exec.Command(name, arg...)
-
-	_, err := cmd.Output()
-	if err != nil {
-		log.Fatal(err)
+func (b *osascriptAudioBackend) SetVolume(volume int) error {
+	// Setting the volume of a device without software volume silently does nothing
+	if _, err := b.getSetting("output volume"); err != nil {
+		return err
 	}
+	_, err := runAudioCommand(b.timeout, "/usr/bin/osascript", "-e", "set volume output volume "+strconv.Itoa(volume))
+	return err
 }
 
-// from 0 to 100
-func setVolume(i int) {
-	//Test first if we can control the mute if not use betterdisplaycli
-	test := getCommandOutput("/usr/bin/osascript", "-e", "output volume of (get volume settings)")
-	if test == "missing value" {
-		volumef := float64(i) / 100
-		currentsource := getCommandOutput("/opt/homebrew/bin/switchaudiosource", "-c")
-		// URL encode the current source name to handle spaces and special characters
-		encodedSource := strings.ReplaceAll(currentsource, " ", "%20")
-		url := fmt.Sprintf("http://localhost:55777/set?name=%s&volume=%f", encodedSource, volumef)
-		resp, err := http.Get(url)
-		if err != nil {
-			log.Printf("Error setting volume for %s: %v", currentsource, err)
-		} else if resp != nil {
-			resp.Body.Close()
-		}
-	} else {
-		runCommand("/usr/bin/osascript", "-e", "set volume output volume "+strconv.Itoa(i))
+func (b *osascriptAudioBackend) GetMute() (bool, error) {
+	output, err := b.getSetting("output muted")
+	if err != nil {
+		return false, err
 	}
+	return strconv.ParseBool(output)
 }
 
-// true - turn mute on
-// false - turn mute off
-func setMute(b bool) {
-	//Test first if we can control the mute if not use betterdisplaycli
-	test := getCommandOutput("/usr/bin/osascript", "-e", "output volume of (get volume settings)")
-	if test == "missing value" {
-		state := "off"
-		if b {
-			state = "on"
-		}
-		currentsource := getCommandOutput("/opt/homebrew/bin/switchaudiosource", "-c")
-		// URL encode the current source name to handle spaces and special characters
-		encodedSource := strings.ReplaceAll(currentsource, " ", "%20")
-		url := fmt.Sprintf("http://localhost:55777/set?name=%s&mute=%s", encodedSource, state)
-		resp, err := http.Get(url)
-		if err != nil {
-			log.Printf("Error setting mute for %s: %v", currentsource, err)
-		} else if resp != nil {
-			resp.Body.Close()
-		}
-	} else {
-		runCommand("/usr/bin/osascript", "-e", "set volume output muted "+strconv.FormatBool(b))
+func (b *osascriptAudioBackend) SetMute(mute bool) error {
+	if _, err := b.getSetting("output volume"); err != nil {
+		return err
 	}
-
-}
-
-func commandSleep() {
-	runCommand("pmset", "sleepnow")
+	_, err := runAudioCommand(b.timeout, "/usr/bin/osascript", "-e", "set volume output muted "+strconv.FormatBool(mute))
+	return err
 }
 
-func commandDisplaySleep() {
-	runCommand("pmset", "displaysleepnow")
+// betterDisplayAudioBackend uses the BetterDisplay HTTP API, which can control the volume of external DACs and HDMI
+// displays. The device is the current output device reported by SwitchAudioSource.
+type betterDisplayAudioBackend struct {
+	baseURL               string
+	switchAudioSourcePath string
+	client                *http.Client
+	timeout               time.Duration
 }
 
-func commandShutdown() {
+func (b *betterDisplayAudioBackend) Name() string { return "betterdisplay" }
 
-	if os.Getuid() == 0 {
-		// if the program is run by root user we are doing the most powerfull shutdown - that always shuts down the computer
-		runCommand("shutdown", "-h", "now")
-	} else {
-		// if the program is run by ordinary user we are trying to shutdown, but it may fail if the other user is logged in
-		runCommand("/usr/bin/osascript", "-e", "tell app \"System Events\" to shut down")
+// request calls the API with the current output device name and returns the trimmed response body
+func (b *betterDisplayAudioBackend) request(action, query string) (string, error) {
+	device, err := runAudioCommand(b.timeout, b.switchAudioSourcePath, "-c", "-t", "output")
+	if err != nil {
+		return "", fmt.Errorf("error getting current output device: %w", err)
 	}
 
-}
-
-func commandDisplayWake() {
-	runCommand("/usr/bin/caffeinate", "-u", "-t", "1")
-}
+	// BetterDisplay expects %20 rather than + for spaces in device names
+	name := strings.ReplaceAll(url.QueryEscape(device), "+", "%20")
+	resp, err := b.client.Get(fmt.Sprintf("%s/%s?name=%s&%s", strings.TrimSuffix(b.baseURL, "/"), action, name, query))
+	if err != nil {
+		return "", fmt.Errorf("BetterDisplay request for %s failed: %w: %w", device, errAudioUnavailable, err)
+	}
+	defer resp.Body.Close()
 
-func commandKeepAwake() {
-	cmd := "/usr/bin/caffeinate -d &"
-	err := exec.Command("/bin/sh", "-c", cmd).Start()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
 	if err != nil {
-		log.Fatal(err)
+		return "", fmt.Errorf("error reading BetterDisplay response for %s: %w", device, err)
 	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("BetterDisplay returned %s for %s: %s", resp.Status, device, strings.TrimSpace(string(body)))
+	}
+	return strings.TrimSpace(string(body)), nil
 }
 
-func commandAllowSleep() {
-	cmd := "/bin/ps ax | /usr/bin/grep caffeinate | /usr/bin/grep -v grep | /usr/bin/awk '{print \"kill \"$1}'|sh"
-	_, err := exec.Command("/bin/sh", "-c", cmd).Output()
+func (b *betterDisplayAudioBackend) GetVolume() (int, error) {
+	output, err := b.request("get", "volume")
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
+	}
+	volume, err := strconv.ParseFloat(output, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid BetterDisplay volume %q", output)
 	}
+	return int(math.Round(volume * 100)), nil
 }
 
-func commandRunShortcut(shortcut string) {
-	runCommand("shortcuts", "run", shortcut)
+func (b *betterDisplayAudioBackend) SetVolume(volume int) error {
+	_, err := b.request("set", fmt.Sprintf("volume=%.2f", float64(volume)/100))
+	return err
 }
 
-func commandScreensaver() {
-	runCommand("open", "-a", "ScreenSaverEngine")
+func (b *betterDisplayAudioBackend) GetMute() (bool, error) {
+	output, err := b.request("get", "mute")
+	if err != nil {
+		return false, err
+	}
+	return output == "on", nil
 }
 
-func commandPlayPause() {
-	runCommand("media-control", "toggle-play-pause")
+func (b *betterDisplayAudioBackend) SetMute(mute bool) error {
+	state := "off"
+	if mute {
+		state = "on"
+	}
+	_, err := b.request("set", "mute="+state)
+	return err
 }
 
-// getDisplays retrieves all available displays using BetterDisplay CLI
-func getDisplays() []Display {
+// switchAudioSourceAudioBackend can only mute and unmute the current output device
+type switchAudioSourceAudioBackend struct {
+	path    string
+	timeout time.Duration
+}
 
-	// Check if BetterDisplay CLI is available
-	if !isBetterDisplayCLIAvailable() {
-		log.Println("BetterDisplay CLI is not installed or not accessible")
-		log.Println("To install BetterDisplay CLI:")
-		log.Println("  1. Install BetterDisplay from https://github.com/waydabber/BetterDisplay")
-		log.Println("  2. Enable CLI access in BetterDisplay preferences")
-		log.Println("  3. Restart the application")
-		log.Println("Display brightness controls will be disabled until BetterDisplay CLI is available")
-		return nil
-	}
+func (b *switchAudioSourceAudioBackend) Name() string { return "switchaudiosource" }
 
-	log.Println("Executing: betterdisplaycli get -identifiers")
-	out, err := exec.Command("betterdisplaycli", "get", "-identifiers").Output()
-	if err != nil {
-		log.Printf("Error getting displays: %v", err)
-		log.Println("BetterDisplay CLI is installed but failed to execute")
-		log.Println("Make sure BetterDisplay is running and CLI access is enabled")
-		return nil
-	}
+func (b *switchAudioSourceAudioBackend) GetVolume() (int, error) { return 0, errAudioUnsupported }
 
-	log.Printf("BetterDisplay CLI output: %s", string(out))
+func (b *switchAudioSourceAudioBackend) SetVolume(volume int) error { return errAudioUnsupported }
 
-	// BetterDisplay CLI returns comma-separated JSON objects, not an array
-	// We need to wrap it in brackets to make it a valid JSON array
-	jsonStr := "[" + string(out) + "]"
+func (b *switchAudioSourceAudioBackend) GetMute() (bool, error) { return false, errAudioUnsupported }
 
-	var displays []Display
-	err = json.Unmarshal([]byte(jsonStr), &displays)
-	if err != nil {
-		log.Printf("Error parsing display JSON: %v", err)
-		log.Println("BetterDisplay CLI returned invalid JSON format")
-		return nil
+func (b *switchAudioSourceAudioBackend) SetMute(mute bool) error {
+	mode := "unmute"
+	if mute {
+		mode = "mute"
 	}
+	_, err := runAudioCommand(b.timeout, b.path, "-t", "output", "-m", mode)
+	return err
+}
 
-	return displays
+// fallbackAudioBackend tries each backend in order until one supports the current output device
+type fallbackAudioBackend struct {
+	backends []AudioBackend
 }
 
-// isDisplayAvailable checks if a display is currently available
-func isDisplayAvailable(displayID string) bool {
-	// Get current display list to check if display is available
-	displays := getDisplays()
-	if displays == nil {
-		return false
-	}
+func (b *fallbackAudioBackend) Name() string { return "auto" }
 
-	for _, display := range displays {
-		if display.DisplayID == displayID {
-			return true
+// try runs fn on each backend, moving to the next one only when a backend does not support the device or cannot be
+// reached
+func (b *fallbackAudioBackend) try(fn func(AudioBackend) error) error {
+	for _, backend := range b.backends {
+		err := fn(backend)
+		if !errors.Is(err, errAudioUnsupported) && !errors.Is(err, errAudioUnavailable) {
+			return err
 		}
 	}
-	return false
+	return errAudioUnsupported
 }
 
-// getDisplayBrightness gets the current brightness for a specific display
-func getDisplayBrightness(displayID string) (int, error) {
-	// First check if display is available to avoid unnecessary errors
-	if !isDisplayAvailable(displayID) {
-		return 0, fmt.Errorf("display %s is not currently available", displayID)
-	}
+func (b *fallbackAudioBackend) GetVolume() (volume int, err error) {
+	err = b.try(func(backend AudioBackend) error {
+		volume, err = backend.GetVolume()
+		return err
+	})
+	return volume, err
+}
 
-	out, err := exec.Command("betterdisplaycli", "get", "-displayID="+displayID, "-brightness", "-value").Output()
-	if err != nil {
-		return 0, fmt.Errorf("error getting brightness for display %s: %v", displayID, err)
-	}
+func (b *fallbackAudioBackend) SetVolume(volume int) error {
+	return b.try(func(backend AudioBackend) error { return backend.SetVolume(volume) })
+}
 
-	// Parse the brightness value (0.0-1.0) and convert to percentage
-	brightnessStr := strings.TrimSpace(string(out))
-	brightness, err := strconv.ParseFloat(brightnessStr, 64)
-	if err != nil {
-		return 0, fmt.Errorf("error parsing brightness value: %v", err)
+func (b *fallbackAudioBackend) GetMute() (mute bool, err error) {
+	err = b.try(func(backend AudioBackend) error {
+		mute, err = backend.GetMute()
+		return err
+	})
+	return mute, err
+}
+
+func (b *fallbackAudioBackend) SetMute(mute bool) error {
+	return b.try(func(backend AudioBackend) error { return backend.SetMute(mute) })
+}
+
+// newAudioBackend creates the configured audio backend
+func newAudioBackend(cfg AudioConfig) (AudioBackend, error) {
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	osascript := &osascriptAudioBackend{timeout: timeout}
+	betterDisplay := &betterDisplayAudioBackend{
+		baseURL:               cfg.BetterDisplayURL,
+		switchAudioSourcePath: cfg.SwitchAudioSourcePath,
+		client:                &http.Client{Timeout: timeout},
+		timeout:               timeout,
+	}
+	switchAudioSource := &switchAudioSourceAudioBackend{path: cfg.SwitchAudioSourcePath, timeout: timeout}
+
+	switch cfg.Backend {
+	case "auto":
+		return &fallbackAudioBackend{backends: []AudioBackend{osascript, betterDisplay, switchAudioSource}}, nil
+	case "osascript":
+		return osascript, nil
+	case "betterdisplay":
+		return betterDisplay, nil
+	case "switchaudiosource":
+		return switchAudioSource, nil
+	default:
+		return nil, fmt.Errorf("unknown audio backend %q", cfg.Backend)
 	}
+}
 
-	return int(brightness * 100), nil
+// AudioDevices holds the audio devices and the current selection
+type AudioDevices struct {
+	Outputs []string `json:"output"`
+	Inputs  []string `json:"input"`
+	Output  string   `json:"-"` // current output device
+	Input   string   `json:"-"` // current input device
 }
 
-// setDisplayBrightness sets the brightness for a specific display
-func setDisplayBrightness(displayID string, brightness int) error {
-	cmd := exec.Command("betterdisplaycli", "set", "-displayID="+displayID, "-brightness="+strconv.Itoa(brightness)+"%")
-	err := cmd.Run()
+func isSwitchAudioSourceAvailable(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// getAudioDevices lists the output and input devices with SwitchAudioSource
+func getAudioDevices(switchAudioSourcePath string) (*AudioDevices, error) {
+	devices := &AudioDevices{}
+	for _, deviceType := range []string{"output", "input"} {
+		list, err := exec.Command(switchAudioSourcePath, "-a", "-t", deviceType).Output()
+		if err != nil {
+			return nil, fmt.Errorf("error listing %s devices: %w", deviceType, err)
+		}
+		current, err := exec.Command(switchAudioSourcePath, "-c", "-t", deviceType).Output()
+		if err != nil {
+			return nil, fmt.Errorf("error getting current %s device: %w", deviceType, err)
+		}
+		if deviceType == "output" {
+			devices.Outputs = nonEmptyLines(string(list))
+			devices.Output = strings.TrimSpace(string(current))
+		} else {
+			devices.Inputs = nonEmptyLines(string(list))
+			devices.Input = strings.TrimSpace(string(current))
+		}
+	}
+	return devices, nil
+}
+
+// setAudioDevice switches the output or input device
+func setAudioDevice(switchAudioSourcePath, deviceType, name string) error {
+	output, err := exec.Command(switchAudioSourcePath, "-t", deviceType, "-s", name).CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("error setting brightness for display %s: %v", displayID, err)
+		return fmt.Errorf("error switching %s device to %s: %v: %s", deviceType, name, err, strings.TrimSpace(string(output)))
 	}
 	return nil
 }
 
-// getMediaInfo retrieves current media information using Media Control
-func getMediaInfo() (*MediaInfo, error) {
-	// Check if Media Control is available
-	if !isMediaControlAvailable() {
-		return nil, &MediaControlError{message: "Media Control is not installed or not accessible"}
+// getInputVolume returns the microphone volume from 0 to 100
+func getInputVolume() (int, error) {
+	output, err := exec.Command("/usr/bin/osascript", "-e", "input volume of (get volume settings)").Output()
+	if err != nil {
+		return 0, fmt.Errorf("error getting input volume: %w", err)
 	}
-
-	// Get media information in JSON format
-	out, err := exec.Command("media-control", "get").Output()
+	volume, err := strconv.Atoi(strings.TrimSpace(string(output)))
 	if err != nil {
-		return nil, fmt.Errorf("error getting media info: %v", err)
+		return 0, fmt.Errorf("input volume not available: %q", strings.TrimSpace(string(output)))
 	}
+	return volume, nil
+}
 
-	// Parse JSON output
-	var mediaData map[string]interface{}
-	if err := json.Unmarshal(out, &mediaData); err != nil {
-		return nil, fmt.Errorf("error parsing media-control JSON output: %v", err)
+func setInputVolume(volume int) error {
+	if err := exec.Command("/usr/bin/osascript", "-e", "set volume input volume "+strconv.Itoa(volume)).Run(); err != nil {
+		return fmt.Errorf("error setting input volume: %w", err)
 	}
+	return nil
+}
 
-	// Check if media is playing
-	playing, ok := mediaData["playing"].(bool)
-	if !ok || !playing {
-		return nil, nil // No media playing
+// setInputMute mutes the microphone by setting its volume to 0, unmuting restores the previous volume
+func (app *Application) setInputMute(mute bool) error {
+	volume, err := getInputVolume()
+	if err != nil {
+		return err
 	}
 
-	// Extract media information
-	mediaInfo := &MediaInfo{}
-
-	// Get title
-	if title, ok := mediaData["title"].(string); ok && title != "" {
-		mediaInfo.Title = title
+	app.audioMutex.Lock()
+	defer app.audioMutex.Unlock()
+	if mute {
+		if volume == 0 {
+			return nil
+		}
+		app.inputVolumeBeforeMute = volume
+		return setInputVolume(0)
 	}
+	if volume > 0 {
+		return nil
+	}
+	restore := app.inputVolumeBeforeMute
+	if restore == 0 {
+		restore = 50
+	}
+	return setInputVolume(restore)
+}
 
-	// Get artist
-	if artist, ok := mediaData["artist"].(string); ok && artist != "" {
-		mediaInfo.Artist = artist
+func (app *Application) updateInputVolume(client mqtt.Client) {
+	volume, err := getInputVolume()
+	if err != nil {
+		log.Printf("Failed to get input volume: %v", err)
+		return
 	}
+	client.Publish(app.getTopicPrefix()+"/status/audio/input_volume", 0, false, strconv.Itoa(volume))
+	client.Publish(app.getTopicPrefix()+"/status/audio/input_mute", 0, false, strconv.FormatBool(volume == 0))
+}
 
-	// Get album
-	if album, ok := mediaData["album"].(string); ok && album != "" {
-		mediaInfo.Album = album
+// diffDevices returns the devices only in a and only in b
+func diffDevices(a, b []string) (onlyA, onlyB []string) {
+	inA := make(map[string]bool)
+	for _, device := range a {
+		inA[device] = true
+	}
+	inB := make(map[string]bool)
+	for _, device := range b {
+		inB[device] = true
+		if !inA[device] {
+			onlyB = append(onlyB, device)
+		}
+	}
+	for _, device := range a {
+		if !inB[device] {
+			onlyA = append(onlyA, device)
+		}
 	}
+	return onlyA, onlyB
+}
 
-	// Get app name
-	if appName, ok := mediaData["appName"].(string); ok && appName != "" {
-		mediaInfo.AppName = appName
+// checkAudioDevices publishes the audio devices and change events when devices come, go or are switched
+func (app *Application) checkAudioDevices(client mqtt.Client) {
+	if !app.audioDevicesAvailable {
+		return
 	}
 
-	// Get duration (in seconds)
-	duration := 0
-	if d, ok := mediaData["duration"].(float64); ok {
-		duration = int(d)
-	} else if d, ok := mediaData["durationMicros"].(float64); ok {
-		duration = int(d / 1000000)
-	} else if d, ok := mediaData["totalTime"].(float64); ok {
-		duration = int(d)
-	} else if d, ok := mediaData["totalDuration"].(float64); ok {
-		duration = int(d)
+	devices, err := getAudioDevices(app.config.Audio.SwitchAudioSourcePath)
+	if err != nil {
+		log.Printf("Failed to get audio devices: %v", err)
+		return
 	}
-	mediaInfo.Duration = duration
 
-	// Get position (in seconds)
-	position := 0
-	if p, ok := mediaData["elapsedTime"].(float64); ok {
-		position = int(p)
-	} else if p, ok := mediaData["position"].(float64); ok {
-		position = int(p)
-	} else if p, ok := mediaData["positionMicros"].(float64); ok {
-		position = int(p / 1000000)
+	app.audioMutex.Lock()
+	last := app.audioDevices
+	app.audioDevices = devices
+	app.audioMutex.Unlock()
+
+	if last != nil {
+		for _, deviceType := range []string{"output", "input"} {
+			lastList, list, lastCurrent, current := last.Outputs, devices.Outputs, last.Output, devices.Output
+			if deviceType == "input" {
+				lastList, list, lastCurrent, current = last.Inputs, devices.Inputs, last.Input, devices.Input
+			}
+			removed, added := diffDevices(lastList, list)
+			for _, device := range added {
+				app.publishEvent(client, "audio_device", map[string]interface{}{"event_type": "added", "type": deviceType, "device": device})
+			}
+			for _, device := range removed {
+				app.publishEvent(client, "audio_device", map[string]interface{}{"event_type": "removed", "type": deviceType, "device": device})
+			}
+			if current != lastCurrent {
+				app.publishEvent(client, "audio_device", map[string]interface{}{
+					"event_type": deviceType + "_changed",
+					"type":       deviceType,
+					"device":     current,
+					"previous":   lastCurrent,
+				})
+			}
+		}
 	}
-	mediaInfo.Position = position
 
-	// Set state based on playing status
-	mediaInfo.State = "playing"
+	// The select options come from the device lists, rebuild discovery when they change
+	if last == nil || strings.Join(last.Outputs, "\n") != strings.Join(devices.Outputs, "\n") ||
+		strings.Join(last.Inputs, "\n") != strings.Join(devices.Inputs, "\n") {
+		app.setDevice(client)
+	}
 
-	return mediaInfo, nil
+	devicesJSON, _ := json.Marshal(devices)
+	client.Publish(app.getTopicPrefix()+"/status/audio/devices", 0, false, string(devicesJSON))
+	client.Publish(app.getTopicPrefix()+"/status/audio/output", 0, false, devices.Output)
+	client.Publish(app.getTopicPrefix()+"/status/audio/input", 0, false, devices.Input)
 }
 
-// updateMediaPlayer updates the MQTT topics with current media player information
-func (app *Application) updateMediaPlayer(client mqtt.Client) {
-	mediaInfo, err := getMediaInfo()
-	if err != nil {
-		// Check if it's a Media Control error
-		if _, ok := err.(*MediaControlError); ok {
-			log.Printf("Media Control is not available: %v", err)
-			log.Println("To install Media Control:")
-			log.Println("  1. Install via npm: npm install -g media-control")
-			log.Println("  2. Or install via Homebrew: brew install media-control")
-			log.Println("Media player information will be disabled until Media Control is available")
-		} else {
-			log.Printf("Error getting media info: %v", err)
+// handleAudioCommand handles audio device selection and microphone commands
+func (app *Application) handleAudioCommand(client mqtt.Client, topic, payload string) bool {
+	switch topic {
+	case app.getTopicPrefix() + "/command/audio/output", app.getTopicPrefix() + "/command/audio/input":
+		deviceType := strings.TrimPrefix(topic, app.getTopicPrefix()+"/command/audio/")
+		if !app.audioDevicesAvailable {
+			log.Printf("Cannot switch %s device, SwitchAudioSource is not installed", deviceType)
+			return true
 		}
-		return
-	}
+		if err := setAudioDevice(app.config.Audio.SwitchAudioSourcePath, deviceType, payload); err != nil {
+			log.Printf("Failed to switch audio device: %v", err)
+		}
+		app.checkAudioDevices(client)
+		app.updateVolume(client)
+		app.updateMute(client)
 
-	// If no media is playing, publish empty state
-	if mediaInfo == nil {
-		log.Println("No media playing - publishing idle state")
-		app.publishMediaState(client, "idle", "", "", "", "", 0, 0)
-		return
-	}
+	case app.getTopicPrefix() + "/command/audio/input_volume":
+		volume, err := app.validateVolumeInput(payload)
+		if err != nil {
+			log.Printf("Invalid input volume value: %v", err)
+			return true
+		}
+		if err := setInputVolume(volume); err != nil {
+			log.Printf("Failed to set input volume: %v", err)
+		}
+		app.updateInputVolume(client)
 
-	// Determine the state
-	state := "idle"
-	switch mediaInfo.State {
-	case "playing":
-		state = "playing"
-	case "paused":
-		state = "paused"
-	case "stopped":
-		state = "idle"
+	case app.getTopicPrefix() + "/command/audio/input_mute":
+		mute, err := app.validateMuteInput(payload)
+		if err != nil {
+			log.Printf("Invalid input mute value: %v", err)
+			return true
+		}
+		if err := app.setInputMute(mute); err != nil {
+			log.Printf("Failed to set input mute: %v", err)
+		}
+		app.updateInputVolume(client)
+
+	default:
+		return false
 	}
+	return true
+}
 
-	log.Printf("Media playing: %s - %s (%s)", mediaInfo.Artist, mediaInfo.Title, state)
-	app.publishMediaState(client, state, mediaInfo.Title, mediaInfo.Artist, mediaInfo.Album, mediaInfo.AppName, mediaInfo.Duration, mediaInfo.Position)
+// Announcement is a queued text-to-speech message or sound
+type Announcement struct {
+	Text   string `json:"text"`
+	Voice  string `json:"voice"`  // say -v
+	Rate   int    `json:"rate"`   // words per minute, say -r
+	Volume *int   `json:"volume"` // output volume while playing, the previous volume is restored afterwards
+	Duck   bool   `json:"duck"`   // lower the volume like the duck command instead, to volume or audio.duck_volume
+	Device string `json:"device"` // output device name, say -a
+	Sound  string `json:"sound"`  // system sound name, played instead of text
 }
 
-// updateNowPlaying updates the now playing sensor with current media information
-func (app *Application) updateNowPlaying(client mqtt.Client) {
-	mediaInfo, err := getMediaInfo()
-	if err != nil {
-		if _, ok := err.(*MediaControlError); ok {
-			log.Printf("Media Control is not available: %v", err)
-			return
-			//revive:disable-next-line
-		} else {
-			log.Printf("Error getting media info: %v", err)
-			return
+// parseAnnouncement parses a say or sound payload, which is either plain text (or a sound name) or JSON
+func parseAnnouncement(payload string, sound bool) (*Announcement, error) {
+	announcement := &Announcement{}
+	if strings.HasPrefix(strings.TrimSpace(payload), "{") {
+		if err := json.Unmarshal([]byte(payload), announcement); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
 		}
+	} else if sound {
+		announcement.Sound = strings.TrimSpace(payload)
+	} else {
+		announcement.Text = payload
 	}
 
-	// If no media is playing, publish idle state
-	if mediaInfo == nil {
-		state := "idle"
-		client.Publish(app.getTopicPrefix()+"/status/now_playing", 0, false, state)
-		attr := map[string]interface{}{
-			"state":    state,
-			"title":    "",
-			"artist":   "",
-			"album":    "",
-			"app_name": "",
-			"duration": 0,
-			"position": 0,
+	if sound {
+		announcement.Text = ""
+		if !regexp.MustCompile(`^[A-Za-z0-9 _-]+$`).MatchString(announcement.Sound) {
+			return nil, fmt.Errorf("invalid sound name %q", announcement.Sound)
+		}
+	} else {
+		announcement.Sound = ""
+		if strings.TrimSpace(announcement.Text) == "" {
+			return nil, fmt.Errorf("text is required")
 		}
-		attrJSON, _ := json.Marshal(attr)
-		client.Publish(app.getTopicPrefix()+"/status/now_playing_attr", 0, false, string(attrJSON))
-		return
 	}
-
-	// Determine the state
-	state := "idle"
-	switch mediaInfo.State {
-	case "playing":
-		state = "playing"
-	case "paused":
-		state = "paused"
-	case "stopped":
-		state = "idle"
+	if announcement.Volume != nil && (*announcement.Volume < MinVolume || *announcement.Volume > MaxVolume) {
+		return nil, fmt.Errorf("volume must be between %d and %d", MinVolume, MaxVolume)
+	}
+	if announcement.Rate < 0 {
+		return nil, fmt.Errorf("rate must be positive")
 	}
+	return announcement, nil
+}
 
-	// Publish state and attributes
-	client.Publish(app.getTopicPrefix()+"/status/now_playing", 0, false, state)
-	attr := map[string]interface{}{
-		"state":    state,
-		"title":    mediaInfo.Title,
-		"artist":   mediaInfo.Artist,
-		"album":    mediaInfo.Album,
-		"app_name": mediaInfo.AppName,
-		"duration": mediaInfo.Duration,
-		"position": mediaInfo.Position,
+// getSystemSounds lists the names of the bundled system sounds
+func getSystemSounds() []string {
+	files, err := filepath.Glob(filepath.Join(SystemSoundsDir, "*.aiff"))
+	if err != nil {
+		return nil
 	}
-	attrJSON, _ := json.Marshal(attr)
-	client.Publish(app.getTopicPrefix()+"/status/now_playing_attr", 0, false, string(attrJSON))
-	log.Printf("Updated now playing sensor: %s - %s (%s)", mediaInfo.Artist, mediaInfo.Title, state)
+	var sounds []string
+	for _, file := range files {
+		sounds = append(sounds, strings.TrimSuffix(filepath.Base(file), ".aiff"))
+	}
+	return sounds
 }
 
-// startMediaStream starts the media-control stream for real-time updates
-func (app *Application) startMediaStream(client mqtt.Client) {
-	if !isMediaControlAvailable() {
-		log.Println("Media Control not available - skipping media stream")
-		return
+// playAnnouncement speaks the text or plays the sound and waits until it finishes
+func (app *Application) playAnnouncement(client mqtt.Client, announcement *Announcement) error {
+	if announcement.Duck {
+		// Leave a duck that was already in place to whoever started it
+		if !app.isVolumeDucked() {
+			level := app.config.Audio.DuckVolume
+			if announcement.Volume != nil {
+				level = *announcement.Volume
+			}
+			app.duckVolume(client, level, 0)
+			defer app.restoreVolume(client)
+		}
+	} else if announcement.Volume != nil {
+		app.stopVolumeFade()
+		previous, err := app.audioBackend.GetVolume()
+		if err != nil {
+			return fmt.Errorf("failed to get volume: %w", err)
+		}
+		if err := app.audioBackend.SetVolume(*announcement.Volume); err != nil {
+			return fmt.Errorf("failed to set volume: %w", err)
+		}
+		defer func() {
+			if err := app.audioBackend.SetVolume(previous); err != nil {
+				log.Printf("Failed to restore volume after announcement: %v", err)
+			}
+			app.updateVolume(client)
+		}()
 	}
 
-	log.Println("Starting media-control stream for real-time updates...")
+	ctx, cancel := context.WithTimeout(context.Background(), AnnouncementTimeout)
+	defer cancel()
 
-	cmd := exec.Command("media-control", "stream")
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Printf("Error creating stdout pipe for media stream: %v", err)
-		return
+	var cmd *exec.Cmd
+	if announcement.Sound != "" {
+		path := filepath.Join(SystemSoundsDir, announcement.Sound+".aiff")
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("unknown sound %q", announcement.Sound)
+		}
+		cmd = exec.CommandContext(ctx, "/usr/bin/afplay", path)
+	} else {
+		args := []string{"-f", "-"}
+		if announcement.Voice != "" {
+			args = append(args, "-v", announcement.Voice)
+		}
+		if announcement.Rate > 0 {
+			args = append(args, "-r", strconv.Itoa(announcement.Rate))
+		}
+		if announcement.Device != "" {
+			args = append(args, "-a", announcement.Device)
+		}
+		// Text goes through stdin so it can never be taken for a flag
+		cmd = exec.CommandContext(ctx, "/usr/bin/say", args...)
+		cmd.Stdin = strings.NewReader(announcement.Text)
 	}
 
-	if err := cmd.Start(); err != nil {
-		log.Printf("Error starting media-control stream: %v", err)
-		return
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %v: %s", filepath.Base(cmd.Path), err, strings.TrimSpace(string(output)))
 	}
+	return nil
+}
 
-	// Read the stream in a goroutine with error recovery
+// startAnnouncer plays queued announcements one at a time so they never overlap
+func (app *Application) startAnnouncer(client mqtt.Client) {
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
-				log.Printf("Media stream goroutine recovered from panic: %v", r)
+				log.Printf("Announcer goroutine recovered from panic: %v", r)
 			}
-			cmd.Wait()
-			stdout.Close()
 		}()
 
-		scanner := bufio.NewScanner(stdout)
-		// Increase buffer size to handle long JSON lines from media-control stream
-		buf := make([]byte, 0, 64*1024) // 64KB buffer
-		scanner.Buffer(buf, 1024*1024)  // Allow up to 1MB tokens
-
-		for scanner.Scan() {
-			line := scanner.Text()
-			if line == "" {
-				continue
+		for announcement := range app.announcements {
+			if err := app.playAnnouncement(client, announcement); err != nil {
+				log.Printf("Announcement failed: %v", err)
 			}
+		}
+	}()
+}
+
+// handleAnnouncementCommand queues say and sound commands
+func (app *Application) handleAnnouncementCommand(topic, payload string) bool {
+	var sound bool
+	switch topic {
+	case app.getTopicPrefix() + "/command/say":
+	case app.getTopicPrefix() + "/command/sound":
+		sound = true
+	default:
+		return false
+	}
+
+	announcement, err := parseAnnouncement(payload, sound)
+	if err != nil {
+		log.Printf("Invalid announcement: %v", err)
+		return true
+	}
+
+	select {
+	case app.announcements <- announcement:
+	default:
+		log.Printf("Announcement queue is full, dropping announcement")
+	}
+	return true
+}
+
+// nonEmptyLines returns the trimmed lines of command output, skipping blank ones
+func nonEmptyLines(output string) []string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// appleScriptString quotes s as an AppleScript string literal
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// Notification is the payload of the notify command
+type Notification struct {
+	ID       string   `json:"id"` // echoed back in the notification event
+	Title    string   `json:"title"`
+	Subtitle string   `json:"subtitle"`
+	Message  string   `json:"message"`
+	Sound    string   `json:"sound"`   // system sound name, e.g. "Glass"
+	Actions  []string `json:"actions"` // action buttons, needs alerter
+	Timeout  int      `json:"timeout"` // seconds before the notification is dismissed, needs alerter
+}
+
+// parseNotification parses a notify payload, which is either the plain message or JSON
+func parseNotification(payload string) (*Notification, error) {
+	notification := &Notification{}
+	if strings.HasPrefix(strings.TrimSpace(payload), "{") {
+		if err := json.Unmarshal([]byte(payload), notification); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+	} else {
+		notification.Message = payload
+	}
+
+	if strings.TrimSpace(notification.Message) == "" {
+		return nil, fmt.Errorf("message is required")
+	}
+	if notification.Title == "" {
+		notification.Title = "mac2mqtt"
+	}
+	// Compare seconds, large timeouts would wrap around as a Duration
+	if notification.Timeout < 0 || notification.Timeout > int(NotificationTimeout/time.Second) {
+		return nil, fmt.Errorf("timeout must be between 0 and %d seconds", int(NotificationTimeout.Seconds()))
+	}
+	for _, action := range notification.Actions {
+		// alerter takes the actions as a comma separated list
+		if strings.Contains(action, ",") || strings.TrimSpace(action) == "" {
+			return nil, fmt.Errorf("invalid action %q", action)
+		}
+	}
+	return notification, nil
+}
+
+// parseAlerterOutput returns the event type and the chosen action from `alerter -json` output
+func parseAlerterOutput(output string) (string, string, error) {
+	// {"activationType":"actionClicked","activationValue":"Snooze","deliveredAt":"2024-05-01 18:04:00 +0200"}
+
+	var result struct {
+		ActivationType  string `json:"activationType"`
+		ActivationValue string `json:"activationValue"`
+	}
+	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		return "", "", fmt.Errorf("invalid alerter output %q: %w", strings.TrimSpace(output), err)
+	}
+
+	switch result.ActivationType {
+	case "actionClicked":
+		return "action", result.ActivationValue, nil
+	case "contentsClicked":
+		return "clicked", "", nil
+	case "replied":
+		return "replied", result.ActivationValue, nil
+	case "closed":
+		return "closed", "", nil
+	case "timeout":
+		return "timeout", "", nil
+	default:
+		return "", "", fmt.Errorf("unknown alerter activation type %q", result.ActivationType)
+	}
+}
+
+// showNotification displays the notification and blocks until the user responds or it times out. Without alerter
+// the notification is shown with AppleScript, which cannot report a response, and the event type is "delivered".
+func (app *Application) showNotification(notification *Notification) (string, string, error) {
+	if _, err := os.Stat(app.config.Notifications.AlerterPath); err != nil {
+		if len(notification.Actions) > 0 {
+			log.Printf("alerter is not installed at %s, showing the notification without actions", app.config.Notifications.AlerterPath)
+		}
+		script := "display notification " + appleScriptString(notification.Message) + " with title " + appleScriptString(notification.Title)
+		if notification.Subtitle != "" {
+			script += " subtitle " + appleScriptString(notification.Subtitle)
+		}
+		if notification.Sound != "" {
+			script += " sound name " + appleScriptString(notification.Sound)
+		}
+		if output, err := exec.Command("/usr/bin/osascript", "-e", script).CombinedOutput(); err != nil {
+			return "", "", fmt.Errorf("osascript failed: %v: %s", err, strings.TrimSpace(string(output)))
+		}
+		return "delivered", "", nil
+	}
+
+	args := []string{"-json", "-title", notification.Title, "-message", notification.Message}
+	if notification.Subtitle != "" {
+		args = append(args, "-subtitle", notification.Subtitle)
+	}
+	if notification.Sound != "" {
+		args = append(args, "-sound", notification.Sound)
+	}
+	if len(notification.Actions) > 0 {
+		args = append(args, "-actions", strings.Join(notification.Actions, ","))
+	}
+	// Without a timeout alerter waits for a response forever
+	timeout := NotificationTimeout
+	if notification.Timeout > 0 {
+		timeout = time.Duration(notification.Timeout) * time.Second
+	}
+	args = append(args, "-timeout", strconv.Itoa(int(timeout.Seconds())))
+	if notification.ID != "" {
+		args = append(args, "-group", notification.ID)
+	}
+
+	// Kill alerter if it hangs past its own timeout
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+time.Minute)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, app.config.Notifications.AlerterPath, args...).Output()
+	if err != nil {
+		return "", "", fmt.Errorf("alerter failed: %w", err)
+	}
+	return parseAlerterOutput(string(output))
+}
+
+// handleNotifyCommand shows a notification and publishes the response as a notification event
+func (app *Application) handleNotifyCommand(client mqtt.Client, topic, payload string) bool {
+	if topic != app.getTopicPrefix()+"/command/notify" {
+		return false
+	}
+
+	notification, err := parseNotification(payload)
+	if err != nil {
+		log.Printf("Invalid notification: %v", err)
+		return true
+	}
+
+	// alerter blocks until the notification is answered, don't hold up the message handler
+	go func() {
+		eventType, action, err := app.showNotification(notification)
+		if err != nil {
+			log.Printf("Failed to show notification: %v", err)
+			eventType = "failed"
+		}
+
+		event := map[string]interface{}{"event_type": eventType, "title": notification.Title}
+		if notification.ID != "" {
+			event["id"] = notification.ID
+		}
+		if action != "" {
+			event["action"] = action
+		}
+		if err != nil {
+			event["error"] = err.Error()
+		}
+		app.publishEvent(client, "notification", event)
+	}()
+	return true
+}
+
+func commandSleep() {
+	runCommand("pmset", "sleepnow")
+}
+
+func commandDisplaySleep() {
+	runCommand("pmset", "displaysleepnow")
+}
+
+func commandShutdown() {
+
+	if os.Getuid() == 0 {
+		// if the program is run by root user we are doing the most powerfull shutdown - that always shuts down the computer
+		runCommand("shutdown", "-h", "now")
+	} else {
+		// if the program is run by ordinary user we are trying to shutdown, but it may fail if the other user is logged in
+		runCommand("/usr/bin/osascript", "-e", "tell app \"System Events\" to shut down")
+	}
+
+}
+
+func commandRestart() {
+	if os.Getuid() == 0 {
+		runCommand("shutdown", "-r", "now")
+	} else {
+		// like shutdown, this may fail if the other user is logged in
+		runCommand("/usr/bin/osascript", "-e", "tell app \"System Events\" to restart")
+	}
+}
+
+func commandLogout() {
+	runCommand("/usr/bin/osascript", "-e", "tell app \"System Events\" to log out")
+}
+
+func commandDisplayWake() {
+	runCommand("/usr/bin/caffeinate", "-u", "-t", "1")
+}
+
+func commandScreensaver() {
+	runCommand("open", "-a", "ScreenSaverEngine")
+}
+
+func commandPlayPause() {
+	runCommand("media-control", "toggle-play-pause")
+}
+
+// getDisplays retrieves all available displays using BetterDisplay CLI
+func getDisplays() []Display {
+
+	// Check if BetterDisplay CLI is available
+	if !isBetterDisplayCLIAvailable() {
+		log.Println("BetterDisplay CLI is not installed or not accessible")
+		log.Println("To install BetterDisplay CLI:")
+		log.Println("  1. Install BetterDisplay from https://github.com/waydabber/BetterDisplay")
+		log.Println("  2. Enable CLI access in BetterDisplay preferences")
+		log.Println("  3. Restart the application")
+		log.Println("Display brightness controls will be disabled until BetterDisplay CLI is available")
+		return nil
+	}
+
+	log.Println("Executing: betterdisplaycli get -identifiers")
+	out, err := exec.Command("betterdisplaycli", "get", "-identifiers").Output()
+	if err != nil {
+		log.Printf("Error getting displays: %v", err)
+		log.Println("BetterDisplay CLI is installed but failed to execute")
+		log.Println("Make sure BetterDisplay is running and CLI access is enabled")
+		return nil
+	}
+
+	log.Printf("BetterDisplay CLI output: %s", string(out))
+
+	// BetterDisplay CLI returns comma-separated JSON objects, not an array
+	// We need to wrap it in brackets to make it a valid JSON array
+	jsonStr := "[" + string(out) + "]"
+
+	var displays []Display
+	err = json.Unmarshal([]byte(jsonStr), &displays)
+	if err != nil {
+		log.Printf("Error parsing display JSON: %v", err)
+		log.Println("BetterDisplay CLI returned invalid JSON format")
+		return nil
+	}
+
+	return displays
+}
+
+// isDisplayAvailable checks if a display is currently available
+func isDisplayAvailable(displayID string) bool {
+	// Get current display list to check if display is available
+	displays := getDisplays()
+	if displays == nil {
+		return false
+	}
+
+	for _, display := range displays {
+		if display.DisplayID == displayID {
+			return true
+		}
+	}
+	return false
+}
+
+// getDisplayBrightness gets the current brightness for a specific display
+func getDisplayBrightness(displayID string) (int, error) {
+	// First check if display is available to avoid unnecessary errors
+	if !isDisplayAvailable(displayID) {
+		return 0, fmt.Errorf("display %s is not currently available", displayID)
+	}
+
+	out, err := exec.Command("betterdisplaycli", "get", "-displayID="+displayID, "-brightness", "-value").Output()
+	if err != nil {
+		return 0, fmt.Errorf("error getting brightness for display %s: %v", displayID, err)
+	}
+
+	// Parse the brightness value (0.0-1.0) and convert to percentage
+	brightnessStr := strings.TrimSpace(string(out))
+	brightness, err := strconv.ParseFloat(brightnessStr, 64)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing brightness value: %v", err)
+	}
+
+	return int(brightness * 100), nil
+}
+
+// setDisplayBrightness sets the brightness for a specific display
+func setDisplayBrightness(displayID string, brightness int) error {
+	cmd := exec.Command("betterdisplaycli", "set", "-displayID="+displayID, "-brightness="+strconv.Itoa(brightness)+"%")
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("error setting brightness for display %s: %v", displayID, err)
+	}
+	return nil
+}
+
+// getMediaInfo retrieves current media information using Media Control
+func getMediaInfo() (*MediaInfo, error) {
+	// Check if Media Control is available
+	if !isMediaControlAvailable() {
+		return nil, &MediaControlError{message: "Media Control is not installed or not accessible"}
+	}
+
+	// Get media information in JSON format
+	out, err := exec.Command("media-control", "get").Output()
+	if err != nil {
+		return nil, fmt.Errorf("error getting media info: %v", err)
+	}
+
+	// Parse JSON output
+	var mediaData map[string]interface{}
+	if err := json.Unmarshal(out, &mediaData); err != nil {
+		return nil, fmt.Errorf("error parsing media-control JSON output: %v", err)
+	}
+
+	// Check if media is playing
+	playing, ok := mediaData["playing"].(bool)
+	if !ok || !playing {
+		return nil, nil // No media playing
+	}
+
+	// Extract media information
+	mediaInfo := &MediaInfo{}
+
+	// Get title
+	if title, ok := mediaData["title"].(string); ok && title != "" {
+		mediaInfo.Title = title
+	}
+
+	// Get artist
+	if artist, ok := mediaData["artist"].(string); ok && artist != "" {
+		mediaInfo.Artist = artist
+	}
+
+	// Get album
+	if album, ok := mediaData["album"].(string); ok && album != "" {
+		mediaInfo.Album = album
+	}
+
+	// Get app name
+	if appName, ok := mediaData["appName"].(string); ok && appName != "" {
+		mediaInfo.AppName = appName
+	}
+
+	// Get duration (in seconds)
+	duration := 0
+	if d, ok := mediaData["duration"].(float64); ok {
+		duration = int(d)
+	} else if d, ok := mediaData["durationMicros"].(float64); ok {
+		duration = int(d / 1000000)
+	} else if d, ok := mediaData["totalTime"].(float64); ok {
+		duration = int(d)
+	} else if d, ok := mediaData["totalDuration"].(float64); ok {
+		duration = int(d)
+	}
+	mediaInfo.Duration = duration
+
+	// Get position (in seconds)
+	position := 0
+	if p, ok := mediaData["elapsedTime"].(float64); ok {
+		position = int(p)
+	} else if p, ok := mediaData["position"].(float64); ok {
+		position = int(p)
+	} else if p, ok := mediaData["positionMicros"].(float64); ok {
+		position = int(p / 1000000)
+	}
+	mediaInfo.Position = position
+
+	// Set state based on playing status
+	mediaInfo.State = "playing"
+
+	return mediaInfo, nil
+}
+
+// updateMediaPlayer updates the MQTT topics with current media player information
+func (app *Application) updateMediaPlayer(client mqtt.Client) {
+	mediaInfo, err := getMediaInfo()
+	if err != nil {
+		// Check if it's a Media Control error
+		if _, ok := err.(*MediaControlError); ok {
+			log.Printf("Media Control is not available: %v", err)
+			log.Println("To install Media Control:")
+			log.Println("  1. Install via npm: npm install -g media-control")
+			log.Println("  2. Or install via Homebrew: brew install media-control")
+			log.Println("Media player information will be disabled until Media Control is available")
+		} else {
+			log.Printf("Error getting media info: %v", err)
+		}
+		return
+	}
+
+	// If no media is playing, publish empty state
+	if mediaInfo == nil {
+		log.Println("No media playing - publishing idle state")
+		app.publishMediaState(client, "idle", "", "", "", "", 0, 0)
+		return
+	}
+
+	// Determine the state
+	state := "idle"
+	switch mediaInfo.State {
+	case "playing":
+		state = "playing"
+	case "paused":
+		state = "paused"
+	case "stopped":
+		state = "idle"
+	}
+
+	log.Printf("Media playing: %s - %s (%s)", mediaInfo.Artist, mediaInfo.Title, state)
+	app.publishMediaState(client, state, mediaInfo.Title, mediaInfo.Artist, mediaInfo.Album, mediaInfo.AppName, mediaInfo.Duration, mediaInfo.Position)
+}
+
+// updateNowPlaying updates the now playing sensor with current media information
+func (app *Application) updateNowPlaying(client mqtt.Client) {
+	mediaInfo, err := getMediaInfo()
+	if err != nil {
+		if _, ok := err.(*MediaControlError); ok {
+			log.Printf("Media Control is not available: %v", err)
+			return
+			//revive:disable-next-line
+		} else {
+			log.Printf("Error getting media info: %v", err)
+			return
+		}
+	}
+
+	// If no media is playing, publish idle state
+	if mediaInfo == nil {
+		state := "idle"
+		client.Publish(app.getTopicPrefix()+"/status/now_playing", 0, false, state)
+		attr := map[string]interface{}{
+			"state":    state,
+			"title":    "",
+			"artist":   "",
+			"album":    "",
+			"app_name": "",
+			"duration": 0,
+			"position": 0,
+		}
+		attrJSON, _ := json.Marshal(attr)
+		client.Publish(app.getTopicPrefix()+"/status/now_playing_attr", 0, false, string(attrJSON))
+		return
+	}
+
+	// Determine the state
+	state := "idle"
+	switch mediaInfo.State {
+	case "playing":
+		state = "playing"
+	case "paused":
+		state = "paused"
+	case "stopped":
+		state = "idle"
+	}
+
+	// Publish state and attributes
+	client.Publish(app.getTopicPrefix()+"/status/now_playing", 0, false, state)
+	attr := map[string]interface{}{
+		"state":    state,
+		"title":    mediaInfo.Title,
+		"artist":   mediaInfo.Artist,
+		"album":    mediaInfo.Album,
+		"app_name": mediaInfo.AppName,
+		"duration": mediaInfo.Duration,
+		"position": mediaInfo.Position,
+	}
+	attrJSON, _ := json.Marshal(attr)
+	client.Publish(app.getTopicPrefix()+"/status/now_playing_attr", 0, false, string(attrJSON))
+	log.Printf("Updated now playing sensor: %s - %s (%s)", mediaInfo.Artist, mediaInfo.Title, state)
+}
+
+// startMediaStream starts the media-control stream for real-time updates
+func (app *Application) startMediaStream(client mqtt.Client) {
+	if !isMediaControlAvailable() {
+		log.Println("Media Control not available - skipping media stream")
+		return
+	}
+
+	log.Println("Starting media-control stream for real-time updates...")
+
+	cmd := exec.Command("media-control", "stream")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		log.Printf("Error creating stdout pipe for media stream: %v", err)
+		return
+	}
+
+	if err := cmd.Start(); err != nil {
+		log.Printf("Error starting media-control stream: %v", err)
+		return
+	}
+
+	// Read the stream in a goroutine with error recovery
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Media stream goroutine recovered from panic: %v", r)
+			}
+			cmd.Wait()
+			stdout.Close()
+		}()
+
+		scanner := bufio.NewScanner(stdout)
+		// Increase buffer size to handle long JSON lines from media-control stream
+		buf := make([]byte, 0, 64*1024) // 64KB buffer
+		scanner.Buffer(buf, 1024*1024)  // Allow up to 1MB tokens
+
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" {
+				continue
+			}
+
+			// Parse the JSON line from the stream
+			var mediaData map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &mediaData); err != nil {
+				log.Printf("Error parsing media stream JSON: %v", err)
+				continue
+			}
+
+			// Process the media update only if MQTT client is connected
+			if client.IsConnected() {
+				app.processMediaStreamUpdate(client, mediaData)
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			log.Printf("Error reading media stream: %v", err)
+			log.Println("Media stream will restart on next application restart")
+		}
+	}()
+
+	log.Println("Media stream started successfully")
+}
+
+// processMediaStreamUpdate processes a single media update from the stream
+func (app *Application) processMediaStreamUpdate(client mqtt.Client, mediaData map[string]interface{}) {
+	// The stream sends {"type":"data","diff":true,"payload":{...}}
+	// Only update fields present in payload
+	payload, ok := mediaData["payload"].(map[string]interface{})
+	if !ok {
+		log.Printf("Media stream: No payload in event, skipping")
+		return
+	}
+
+	// Merge payload into currentMediaState
+	app.mediaMutex.Lock()
+	for k, v := range payload {
+		switch k {
+		case "title":
+			if s, ok := v.(string); ok {
+				app.currentMediaState.Title = s
+			}
+		case "artist":
+			if s, ok := v.(string); ok {
+				app.currentMediaState.Artist = s
+			}
+		case "album":
+			if s, ok := v.(string); ok {
+				app.currentMediaState.Album = s
+			}
+		case "appName":
+			if s, ok := v.(string); ok {
+				app.currentMediaState.AppName = s
+			}
+		case "bundleIdentifier":
+			if s, ok := v.(string); ok {
+				app.currentMediaState.AppName = s
+			}
+		case "playing":
+			if b, ok := v.(bool); ok {
+				if b {
+					app.currentMediaState.State = "playing"
+				} else {
+					app.currentMediaState.State = "paused"
+				}
+			}
+		case "duration":
+			if f, ok := v.(float64); ok {
+				app.currentMediaState.Duration = int(f)
+			}
+		case "durationMicros":
+			if f, ok := v.(float64); ok {
+				app.currentMediaState.Duration = int(f / 1000000)
+			}
+		case "totalTime":
+			if f, ok := v.(float64); ok {
+				app.currentMediaState.Duration = int(f)
+			}
+		case "totalDuration":
+			if f, ok := v.(float64); ok {
+				app.currentMediaState.Duration = int(f)
+			}
+		case "elapsedTime":
+			if f, ok := v.(float64); ok {
+				app.currentMediaState.Position = int(f)
+			}
+		case "position":
+			if f, ok := v.(float64); ok {
+				app.currentMediaState.Position = int(f)
+			}
+		case "positionMicros":
+			if f, ok := v.(float64); ok {
+				app.currentMediaState.Position = int(f / 1000000)
+			}
+		}
+	}
+
+	// If playing is false and no other info, treat as idle
+	if state, ok := payload["playing"]; ok {
+		if b, ok := state.(bool); ok && !b {
+			app.currentMediaState.State = "idle"
+		}
+	}
+	media := app.currentMediaState
+	app.mediaMutex.Unlock()
+
+	// Publish state and attributes
+	client.Publish(app.getTopicPrefix()+"/status/now_playing", 0, false, media.State)
+	attr := map[string]interface{}{
+		"state":    media.State,
+		"title":    media.Title,
+		"artist":   media.Artist,
+		"album":    media.Album,
+		"app_name": media.AppName,
+		"duration": media.Duration,
+		"position": media.Position,
+	}
+	attrJSON, _ := json.Marshal(attr)
+	client.Publish(app.getTopicPrefix()+"/status/now_playing_attr", 0, false, string(attrJSON))
+	log.Printf("Media stream update: %s - %s (%s)", media.Artist, media.Title, media.State)
+}
+
+// getMediaState returns the media state kept up to date by the media stream
+func (app *Application) getMediaState() MediaInfo {
+	app.mediaMutex.RLock()
+	defer app.mediaMutex.RUnlock()
+	return app.currentMediaState
+}
+
+// getUserActivityState gets the current user activity state
+func (app *Application) getUserActivityState() string {
+	app.activityMutex.RLock()
+	defer app.activityMutex.RUnlock()
+	return app.userActivityState
+}
+
+// setUserActivityState sets the user activity state and publishes to MQTT
+func (app *Application) setUserActivityState(client mqtt.Client, state string) {
+	app.activityMutex.Lock()
+	defer app.activityMutex.Unlock()
+
+	if app.userActivityState != state {
+		app.userActivityState = state
+		if client != nil && client.IsConnected() {
+			client.Publish(app.getTopicPrefix()+"/status/user_activity", 0, false, state)
+			log.Printf("User activity state changed to: %s", state)
+		}
+	}
+}
+
+// resetActivityTimer resets the inactivity timer
+func (app *Application) resetActivityTimer(client mqtt.Client) {
+	app.activityMutex.Lock()
+	defer app.activityMutex.Unlock()
+
+	// Set to active immediately
+	if app.userActivityState != "active" {
+		app.userActivityState = "active"
+		if client != nil && client.IsConnected() {
+			client.Publish(app.getTopicPrefix()+"/status/user_activity", 0, false, "active")
+			log.Printf("User activity detected - state: active")
+		}
+	}
+
+	// Reset or create the timer
+	if app.activityTimer != nil {
+		app.activityTimer.Stop()
+	}
+
+	app.activityTimer = time.AfterFunc(time.Duration(app.config.IdleActivityTime)*time.Second, func() {
+		app.setUserActivityState(client, "inactive")
+	})
+}
+
+// getSystemIdleTime gets the system idle time in seconds
+func getSystemIdleTime() (int, error) {
+	cmd := exec.Command("ioreg", "-c", "IOHIDSystem")
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("error running ioreg: %w", err)
+	}
+
+	// Parse the HIDIdleTime from the output
+	re := regexp.MustCompile(`"HIDIdleTime" = (\d+)`)
+	matches := re.FindStringSubmatch(string(output))
+	if len(matches) < 2 {
+		return 0, fmt.Errorf("HIDIdleTime not found in ioreg output")
+	}
+
+	idleTimeNanos, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing idle time: %w", err)
+	}
+
+	// Convert nanoseconds to seconds
+	idleTimeSeconds := int(idleTimeNanos / 1000000000)
+	return idleTimeSeconds, nil
+}
+
+// startUserActivityMonitoring starts monitoring user activity using system idle time
+func (app *Application) startUserActivityMonitoring(client mqtt.Client) {
+	// Cancel existing monitoring if running
+	app.activityMutex.Lock()
+	if app.activityCancel != nil {
+		app.activityCancel()
+	}
+	// Create new context
+	app.activityCtx, app.activityCancel = context.WithCancel(context.Background())
+	ctx := app.activityCtx
+	app.activityMutex.Unlock()
+
+	log.Println("Starting user activity monitoring...")
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Activity monitor goroutine recovered from panic: %v", r)
+			}
+		}()
+
+		var lastIdleTime int = -1
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("Stopping user activity monitoring (context cancelled)")
+				return
+			case <-ticker.C:
+				// Check if client is still connected
+				if client == nil || !client.IsConnected() {
+					continue
+				}
+
+				idleTime, err := getSystemIdleTime()
+				if err != nil {
+					log.Printf("Error getting system idle time: %v", err)
+					continue
+				}
+
+				// If idle time decreased or is very small, user is active
+				if idleTime < lastIdleTime || idleTime < 2 {
+					app.resetActivityTimer(client)
+				}
+
+				lastIdleTime = idleTime
+				client.Publish(app.getTopicPrefix()+"/status/idle_time_seconds", 0, false, fmt.Sprintf("%d", idleTime))
+			}
+		}
+	}()
+
+	log.Println("User activity monitoring started successfully")
+}
+
+// publishMediaState publishes the current media state to MQTT
+func (app *Application) publishMediaState(client mqtt.Client, state, title, artist, album, appName string, duration, position int) {
+	// Publish individual attributes
+	client.Publish(app.getTopicPrefix()+"/status/media_state", 0, false, state)
+	client.Publish(app.getTopicPrefix()+"/status/media_title", 0, false, title)
+	client.Publish(app.getTopicPrefix()+"/status/media_artist", 0, false, artist)
+	client.Publish(app.getTopicPrefix()+"/status/media_album", 0, false, album)
+	client.Publish(app.getTopicPrefix()+"/status/media_app", 0, false, appName)
+	client.Publish(app.getTopicPrefix()+"/status/media_duration", 0, false, strconv.Itoa(duration))
+	client.Publish(app.getTopicPrefix()+"/status/media_position", 0, false, strconv.Itoa(position))
+
+	// Publish combined JSON state for media_player entity
+	mediaState := map[string]interface{}{
+		"state":        state,
+		"title":        title,
+		"artist":       artist,
+		"album":        album,
+		"app_name":     appName,
+		"duration":     duration,
+		"position":     position,
+		"media_title":  title,
+		"media_artist": artist,
+		"media_album":  album,
+	}
+
+	stateJSON, _ := json.Marshal(mediaState)
+	mediaPlayerTopic := app.getTopicPrefix() + "/status/media_player"
+	client.Publish(mediaPlayerTopic, 0, false, string(stateJSON))
+	log.Printf("Published media state to %s: %s", mediaPlayerTopic, string(stateJSON))
+}
+
+// updateDisplayBrightness updates the MQTT topics with current display brightness values
+func (app *Application) updateDisplayBrightness(client mqtt.Client) {
+	// Skip if no displays are available
+	if len(app.displays) == 0 {
+		return
+	}
+
+	// Refresh display list to handle dynamic display changes (laptop open/close)
+	currentDisplays := getDisplays()
+	if currentDisplays != nil {
+		app.displays = currentDisplays
+	}
+
+	for _, display := range app.displays {
+		brightness, err := getDisplayBrightness(display.DisplayID)
+		if err != nil {
+			// Only log error once per minute to avoid spam for unavailable displays (e.g., closed laptop)
+			if display.Name == "Built-in Display" || strings.Contains(display.Name, "Built-in") {
+				// Silently skip built-in display when unavailable (laptop closed)
+				continue
+			}
+			log.Printf("Error getting brightness for display %s: %v", display.Name, err)
+			// Check if it's a BetterDisplay CLI error
+			if !isBetterDisplayCLIAvailable() {
+				log.Printf("BetterDisplay CLI is not available for display %s", display.Name)
+			}
+			continue
+		}
+
+		statusTopic := app.getTopicPrefix() + "/status/display_" + display.DisplayID + "_brightness"
+		client.Publish(statusTopic, 0, true, strconv.Itoa(brightness))
+	}
+}
+
+func (app *Application) messagePubHandler(client mqtt.Client, msg mqtt.Message) {
+	log.Printf("Received message: %s from topic: %s\n", msg.Payload(), msg.Topic())
+	app.listen(client, msg)
+}
+
+func (app *Application) connectHandler(client mqtt.Client) {
+	log.Println("Connected to MQTT")
+
+	// Set up device configuration (in case this is a reconnection)
+	app.setDevice(client)
+
+	token := client.Publish(app.getTopicPrefix()+"/status/alive", 0, true, "online")
+	token.Wait()
+
+	log.Println("Sending 'online' to topic: " + app.getTopicPrefix() + "/status/alive")
+	app.sub(client, app.getTopicPrefix()+"/command/#")
+
+	// Start media stream if not already running (for reconnections)
+	if isMediaControlAvailable() {
+		go app.startMediaStream(client)
+	}
+
+	// Start user activity monitoring
+	go app.startUserActivityMonitoring(client)
+
+	// Send initial state updates
+	app.updateVolume(client)
+	app.updateMute(client)
+	app.updateCaffeinateStatus(client)
+	app.updateScheduledAction(client)
+	app.updateDisplayBrightness(client)
+	app.updateNowPlaying(client)
+	app.setUserActivityState(client, "inactive") // Initial state
+}
+
+func (app *Application) connectLostHandler(_ mqtt.Client, err error) {
+	log.Printf("Disconnected from MQTT: %v", err)
+
+	// Check if it's a network issue
+	if !app.isNetworkReachable() {
+		log.Println("MQTT broker is not reachable - likely on a different network")
+		log.Println("Will retry connection when network becomes available")
+	} else {
+		log.Println("MQTT client will attempt to reconnect automatically...")
+	}
+}
+
+func (app *Application) getMQTTClient() error {
+	return app.getMQTTClientWithRetry(0)
+}
+
+// isNetworkReachable checks if the MQTT broker is reachable before attempting connection
+func (app *Application) isNetworkReachable() bool {
+	// Try to connect to the broker with a short timeout
+	timeout := 5 * time.Second
+	if _, err := dialTCP(app.config.IP, app.config.Port, timeout); err != nil {
+		log.Printf("Network check failed: MQTT broker %s:%s is not reachable (%v)", app.config.IP, app.config.Port, err)
+		return false
+	}
+	return true
+}
+
+// dialTCP opens and closes a TCP connection to host:port and returns how long the connect took
+func dialTCP(host, port string, timeout time.Duration) (time.Duration, error) {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), timeout)
+	if err != nil {
+		return 0, err
+	}
+	elapsed := time.Since(start)
+	conn.Close()
+	return elapsed, nil
+}
+
+func (app *Application) getMQTTClientWithRetry(retryCount int) error {
+	// Prevent infinite recursion
+	if retryCount > MaxRetryAttempts {
+		return fmt.Errorf("failed to connect to MQTT broker after multiple attempts")
+	}
+
+	// Check network reachability first to avoid long timeouts
+	if !app.isNetworkReachable() {
+		log.Printf("MQTT broker is not reachable on current network, will retry later")
+		return fmt.Errorf("MQTT broker not reachable")
+	}
+
+	opts := mqtt.NewClientOptions()
+
+	// Determine protocol and broker URL
+	protocol := "tcp"
+	if app.config.SSL {
+		protocol = "ssl"
+	}
+	brokerURL := fmt.Sprintf("%s://%s:%s", protocol, app.config.IP, app.config.Port)
+	log.Printf("Connecting to MQTT broker: %s", brokerURL)
+
+	opts.AddBroker(brokerURL)
+	if app.config.User != "" {
+		opts.SetUsername(app.config.User)
+	}
+	if app.config.Password != "" {
+		opts.SetPassword(app.config.Password)
+	}
+
+	// Set up handlers with application context
+	opts.OnConnect = app.connectHandler
+	opts.OnConnectionLost = app.connectLostHandler
+	opts.SetDefaultPublishHandler(app.messagePubHandler)
+
+	// Set client ID to ensure unique identification with timestamp to avoid conflicts
+	clientID := fmt.Sprintf("%s_mac2mqtt_%d", app.hostname, time.Now().Unix())
+	opts.SetClientID(clientID)
+
+	// Network-aware connection reliability settings
+	opts.SetClientID(app.hostname + "_mac2mqtt")
+	opts.SetKeepAlive(60 * time.Second)      // Send ping every 60 seconds
+	opts.SetPingTimeout(10 * time.Second)    // Shorter ping timeout for faster network change detection
+	opts.SetConnectTimeout(15 * time.Second) // Shorter connect timeout for network switching
+	opts.SetAutoReconnect(true)              // Enable auto-reconnect
+	opts.SetConnectRetry(true)
+	opts.SetConnectRetryInterval(15 * time.Second) // Wait 15 seconds between retries (good for network switches)
+	opts.SetMaxReconnectInterval(2 * time.Minute)  // Max 2 minutes between reconnect attempts (faster recovery)
+	opts.SetCleanSession(false)                    // Resume session to avoid losing subscriptions
+	opts.SetOrderMatters(false)                    // Allow out-of-order delivery for better performance
+	opts.SetWriteTimeout(10 * time.Second)         // Shorter write timeout for network issues
+	opts.SetResumeSubs(true)                       // Resume subscriptions on reconnect
+
+	// Set will message
+	opts.SetWill(app.getTopicPrefix()+"/status/alive", "offline", 0, true)
+
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		// If SSL connection fails, try falling back to non-SSL
+		if app.config.SSL {
+			log.Printf("SSL connection failed: %v. Trying non-SSL connection...", token.Error())
+			app.config.SSL = false
+			return app.getMQTTClientWithRetry(retryCount + 1)
+		}
+		return fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
+	}
+
+	app.client = client
+	return nil
+}
+
+func (app *Application) sub(client mqtt.Client, topic string) {
+	token := client.Subscribe(topic, 0, nil)
+	token.Wait()
+	log.Printf("Subscribed to topic: %s\n", topic)
+}
+
+func (app *Application) listen(client mqtt.Client, msg mqtt.Message) {
+	topic := msg.Topic()
+	payload := string(msg.Payload())
+
+	// A retained command runs again on every reconnect, a stale shutdown would power off the Mac each time
+	if msg.Retained() {
+		log.Printf("Ignoring retained command on %s", topic)
+		return
+	}
+
+	// Handle confirmations of armed commands
+	if app.handleConfirmCommand(client, topic, payload) {
+		return
+	}
+
+	// Destructive commands wait for a confirmation
+	if app.needsConfirmation(topic, payload) {
+		app.armCommand(client, topic, payload)
+		return
+	}
+
+	app.dispatch(client, topic, payload)
+}
+
+// dispatch passes a command to its handler
+func (app *Application) dispatch(client mqtt.Client, topic, payload string) {
+	// Handle volume commands
+	if app.handleVolumeCommand(client, topic, payload) {
+		return
+	}
+
+	// Handle relative, fade and duck volume commands
+	if app.handleVolumeRampCommand(client, topic, payload) {
+		return
+	}
+
+	// Handle mute commands
+	if app.handleMuteCommand(client, topic, payload) {
+		return
+	}
+
+	// Handle system commands
+	if app.handleSystemCommand(client, topic, payload) {
+		return
+	}
+
+	// Handle display brightness commands
+	if app.handleDisplayBrightnessCommand(client, topic, payload) {
+		return
+	}
+
+	// Handle shortcut commands
+	if app.handleShortcutCommand(client, topic, payload) {
+		return
+	}
+
+	// Handle open commands
+	if app.handleOpenCommand(topic, payload) {
+		return
+	}
+
+	// Handle keep awake commands
+	if app.handleKeepAwakeCommand(client, topic, payload) {
+		return
+	}
+
+	// Handle play/pause commands
+	if app.handlePlayPauseCommand(client, topic, payload) {
+		return
+	}
+
+	// Handle eject commands
+	if app.handleEjectCommand(client, topic, payload) {
+		return
+	}
+
+	// Handle audio device and microphone commands
+	if app.handleAudioCommand(client, topic, payload) {
+		return
+	}
+
+	// Handle text-to-speech and sound commands
+	if app.handleAnnouncementCommand(topic, payload) {
+		return
+	}
+
+	// Handle notification commands
+	if app.handleNotifyCommand(client, topic, payload) {
+		return
+	}
+
+	// Handle custom commands from the config
+	if app.handleCustomCommand(client, topic, payload) {
+		return
+	}
+
+	// Handle watched application commands
+	if app.handleAppCommand(client, topic, payload) {
+		return
+	}
+}
+
+// destructiveActions are the system actions that have to be confirmed
+var destructiveActions = map[string]bool{
+	"shutdown": true,
+	"restart":  true,
+	"logout":   true,
+}
+
+// PendingConfirmation is a destructive command waiting for its nonce on /command/confirm
+type PendingConfirmation struct {
+	Nonce   string
+	Topic   string
+	Payload string
+	Expires time.Time
+	timer   *time.Timer // drops the confirmation when it expires
+}
+
+// needsConfirmation tells whether the command is destructive and has to be armed first
+func (app *Application) needsConfirmation(topic, payload string) bool {
+	prefix := app.getTopicPrefix() + "/command/"
+	switch {
+	case topic == prefix+"set":
+		scheduled, err := validateSystemCommandInput(payload, time.Now())
+		return err == nil && destructiveActions[scheduled.Action]
+	case strings.HasPrefix(topic, prefix+"custom/"):
+		name := strings.TrimPrefix(topic, prefix+"custom/")
+		for _, command := range app.config.Commands {
+			if command.Name == name {
+				return command.Dangerous
+			}
+		}
+	}
+	return false
+}
+
+// describeCommand names a command for events and the confirmation dialog, like "shutdown" or "custom/backup home"
+func (app *Application) describeCommand(topic, payload string) string {
+	command := strings.TrimPrefix(topic, app.getTopicPrefix()+"/command/")
+	if command == "set" {
+		return strings.TrimSpace(payload)
+	}
+	return strings.TrimSpace(command + " " + payload)
+}
+
+// armCommand holds a destructive command until its nonce is sent to /command/confirm
+func (app *Application) armCommand(client mqtt.Client, topic, payload string) {
+	nonceBytes := make([]byte, 8)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		log.Printf("Failed to create a confirmation nonce: %v", err)
+		return
+	}
+	timeout := time.Duration(app.config.Confirm.Timeout) * time.Second
+	pending := &PendingConfirmation{
+		Nonce:   fmt.Sprintf("%x", nonceBytes),
+		Topic:   topic,
+		Payload: payload,
+		Expires: time.Now().Add(timeout),
+	}
+	command := app.describeCommand(topic, payload)
+
+	app.confirmMutex.Lock()
+	app.pendingConfirmations[pending.Nonce] = pending
+	pending.timer = time.AfterFunc(timeout, func() {
+		if app.takeConfirmation(pending.Nonce) != nil {
+			log.Printf("Confirmation for %s expired", command)
+			app.publishEvent(client, "confirm", map[string]interface{}{"event_type": "expired", "nonce": pending.Nonce, "command": command})
+		}
+	})
+	app.confirmMutex.Unlock()
+
+	log.Printf("Armed %s, waiting for confirmation", command)
+	app.publishEvent(client, "confirm", map[string]interface{}{
+		"event_type": "armed",
+		"nonce":      pending.Nonce,
+		"command":    command,
+		"expires":    pending.Expires.Format(time.RFC3339),
+	})
+}
+
+// takeConfirmation removes and returns the pending confirmation for the nonce, nil when there is none
+func (app *Application) takeConfirmation(nonce string) *PendingConfirmation {
+	app.confirmMutex.Lock()
+	defer app.confirmMutex.Unlock()
+
+	pending := app.pendingConfirmations[nonce]
+	if pending != nil {
+		pending.timer.Stop()
+		delete(app.pendingConfirmations, nonce)
+	}
+	return pending
+}
+
+// errDialogCancelled is returned by confirmOnMac when Cancel is clicked
+var errDialogCancelled = errors.New("cancelled on the Mac")
+
+// confirmOnMac shows a dialog on the Mac before a confirmed command runs, it runs unless the dialog is cancelled or
+// cannot be shown
+func (app *Application) confirmOnMac(command string) error {
+	timeout := app.config.Confirm.DialogTimeout
+	message := fmt.Sprintf("Home automation will run %q in %d seconds.", command, timeout)
+	script := fmt.Sprintf(`display dialog %s with title "mac2mqtt" buttons {"Cancel", "Run Now"} default button "Run Now" `+
+		`cancel button "Cancel" giving up after %d with icon caution`, appleScriptString(message), timeout)
+
+	// A cancelled dialog exits with error -128, as does one that cannot be shown with another error, both stop the command
+	output, err := exec.Command("/usr/bin/osascript", "-e", script).CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(output), "(-128)") {
+			return errDialogCancelled
+		}
+		return fmt.Errorf("confirmation dialog could not be shown: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// handleConfirmCommand handles confirming and cancelling armed commands
+func (app *Application) handleConfirmCommand(client mqtt.Client, topic, payload string) bool {
+	prefix := app.getTopicPrefix() + "/command/confirm"
+	if topic != prefix && topic != prefix+"/cancel" {
+		return false
+	}
+
+	pending := app.takeConfirmation(strings.TrimSpace(payload))
+	if pending == nil {
+		log.Printf("Unknown or expired confirmation nonce: %s", payload)
+		return true
+	}
+	command := app.describeCommand(pending.Topic, pending.Payload)
+
+	if topic == prefix+"/cancel" {
+		log.Printf("Cancelled %s", command)
+		app.publishEvent(client, "confirm", map[string]interface{}{"event_type": "cancelled", "nonce": pending.Nonce, "command": command})
+		return true
+	}
+
+	go func() {
+		if app.config.Confirm.Dialog {
+			if err := app.confirmOnMac(command); err != nil {
+				log.Printf("Cancelled %s: %v", command, err)
+				event := map[string]interface{}{"event_type": "cancelled", "nonce": pending.Nonce, "command": command}
+				if !errors.Is(err, errDialogCancelled) {
+					event["error"] = err.Error()
+				}
+				app.publishEvent(client, "confirm", event)
+				return
+			}
+		}
+		log.Printf("Confirmed %s", command)
+		app.publishEvent(client, "confirm", map[string]interface{}{"event_type": "confirmed", "nonce": pending.Nonce, "command": command})
+		app.dispatch(client, pending.Topic, pending.Payload)
+	}()
+	return true
+}
+
+// handleVolumeCommand handles volume control commands
+func (app *Application) handleVolumeCommand(client mqtt.Client, topic, payload string) bool {
+	if topic != app.getTopicPrefix()+"/command/volume" {
+		return false
+	}
+
+	volume, err := app.validateVolumeInput(payload)
+	if err != nil {
+		log.Printf("Invalid volume value: %v", err)
+		return true
+	}
+
+	// An absolute volume replaces any fade in progress and any ducking
+	app.stopVolumeFade()
+	app.forgetDuck()
+	if err := app.audioBackend.SetVolume(volume); err != nil {
+		log.Printf("Failed to set volume with %s backend: %v", app.audioBackend.Name(), err)
+	}
+	app.updateVolume(client)
+	app.updateMute(client)
+	return true
+}
+
+// handleMuteCommand handles mute control commands
+func (app *Application) handleMuteCommand(client mqtt.Client, topic, payload string) bool {
+	if topic != app.getTopicPrefix()+"/command/mute" {
+		return false
+	}
+
+	mute, err := app.validateMuteInput(payload)
+	if err != nil {
+		log.Printf("Invalid mute value: %v", err)
+		return true
+	}
+
+	if err := app.audioBackend.SetMute(mute); err != nil {
+		log.Printf("Failed to set mute with %s backend: %v", app.audioBackend.Name(), err)
+	}
+	app.updateVolume(client)
+	app.updateMute(client)
+	return true
+}
+
+// systemActions are the actions of /command/set
+var systemActions = map[string]func(){
+	"sleep":        commandSleep,
+	"displaysleep": commandDisplaySleep,
+	"displaywake":  commandDisplayWake,
+	"shutdown":     commandShutdown,
+	"restart":      commandRestart,
+	"logout":       commandLogout,
+	"screensaver":  commandScreensaver,
+}
+
+// powerActions are the system actions held back by the power guard
+var powerActions = map[string]bool{
+	"sleep":    true,
+	"shutdown": true,
+	"restart":  true,
+	"logout":   true,
+}
+
+// SystemCommandResult reports what happened to a system action
+type SystemCommandResult struct {
+	Action   string           `json:"action"`
+	Status   string           `json:"status"` // done, refused or deferred
+	Reason   string           `json:"reason,omitempty"`
+	At       string           `json:"at,omitempty"`       // when a deferred action is checked again
+	Replaced *ScheduledAction `json:"replaced,omitempty"` // the scheduled action a deferred one took the place of
+}
+
+// powerGuardReason returns why the power guard holds back the action, or "" when it may run
+func (app *Application) powerGuardReason(scheduled *ScheduledAction) string {
+	if app.config.PowerGuard.Mode == "off" || scheduled.Force || !powerActions[scheduled.Action] {
+		return ""
+	}
+	if app.getUserActivityState() == "active" {
+		return "user is active"
+	}
+	if app.getMediaState().State == "playing" {
+		return "media is playing"
+	}
+	return ""
+}
+
+// runSystemAction runs a system action, unless the power guard refuses or defers it
+func (app *Application) runSystemAction(client mqtt.Client, scheduled *ScheduledAction) {
+	result := SystemCommandResult{Action: scheduled.Action, Status: "done"}
+
+	if reason := app.powerGuardReason(scheduled); reason != "" {
+		guard := app.config.PowerGuard
+		result.Status, result.Reason = "refused", reason
+
+		now := time.Now()
+		if guard.Mode == "defer" && (scheduled.DeferredSince == nil ||
+			now.Sub(*scheduled.DeferredSince) < time.Duration(guard.MaxDefer)*time.Second) {
+			deferred := *scheduled
+			if deferred.DeferredSince == nil {
+				deferred.DeferredSince = &now
+			}
+			deferred.At = now.Add(time.Duration(guard.Retry) * time.Second)
+			result.Replaced = app.scheduleAction(client, &deferred)
+			result.Status, result.At = "deferred", deferred.At.Format(time.RFC3339)
+		}
+		log.Printf("Power guard %s %s: %s", result.Status, scheduled.Action, reason)
+	}
+
+	// Publish first, the action may take the network down
+	if client.IsConnected() {
+		resultJSON, _ := json.Marshal(result)
+		token := client.Publish(app.getTopicPrefix()+"/status/system/result", 0, false, string(resultJSON))
+		token.WaitTimeout(2 * time.Second)
+	}
+	if result.Status == "done" {
+		systemActions[scheduled.Action]()
+	}
+}
+
+// ScheduledAction is a system action to run later, saved so it survives restarts
+type ScheduledAction struct {
+	Action        string     `json:"action"`
+	At            time.Time  `json:"at"`
+	Force         bool       `json:"force,omitempty"`          // skip the power guard
+	DeferredSince *time.Time `json:"deferred_since,omitempty"` // first time the power guard deferred the action
+}
+
+// handleSystemCommand handles system control commands
+func (app *Application) handleSystemCommand(client mqtt.Client, topic, payload string) bool {
+	if topic != app.getTopicPrefix()+"/command/set" {
+		return false
+	}
+
+	if strings.TrimSpace(payload) == "cancel" {
+		app.cancelScheduledAction(client)
+		return true
+	}
+
+	scheduled, err := validateSystemCommandInput(payload, time.Now())
+	if err != nil {
+		log.Printf("Unknown system command: %v", err)
+		return true
+	}
+
+	if scheduled.At.IsZero() {
+		app.runSystemAction(client, scheduled)
+	} else {
+		app.scheduleAction(client, scheduled)
+	}
+	return true
+}
+
+// scheduleAction replaces the pending scheduled action and returns the one it replaced, if any
+func (app *Application) scheduleAction(client mqtt.Client, scheduled *ScheduledAction) *ScheduledAction {
+	app.scheduleMutex.Lock()
+	replaced := app.scheduledAction
+	if app.scheduleTimer != nil {
+		app.scheduleTimer.Stop()
+	}
+	app.scheduledAction = scheduled
+	app.scheduleTimer = time.AfterFunc(time.Until(scheduled.At), func() {
+		app.runScheduledAction(client, scheduled)
+	})
+	app.scheduleMutex.Unlock()
+
+	if replaced != nil {
+		log.Printf("Replaced scheduled %s at %s", replaced.Action, replaced.At.Format(time.RFC3339))
+	}
+	log.Printf("Scheduled %s at %s", scheduled.Action, scheduled.At.Format(time.RFC3339))
+	app.saveScheduledAction(scheduled)
+	if client.IsConnected() {
+		app.updateScheduledAction(client)
+	}
+	return replaced
+}
+
+// cancelScheduledAction drops the pending scheduled action, if any
+func (app *Application) cancelScheduledAction(client mqtt.Client) {
+	app.scheduleMutex.Lock()
+	scheduled := app.scheduledAction
+	if app.scheduleTimer != nil {
+		app.scheduleTimer.Stop()
+	}
+	app.scheduledAction = nil
+	app.scheduleTimer = nil
+	app.scheduleMutex.Unlock()
+
+	if scheduled != nil {
+		log.Printf("Cancelled scheduled %s at %s", scheduled.Action, scheduled.At.Format(time.RFC3339))
+	}
+	app.saveScheduledAction(nil)
+	if client.IsConnected() {
+		app.updateScheduledAction(client)
+	}
+}
+
+// runScheduledAction runs a scheduled action unless it was cancelled or replaced in the meantime
+func (app *Application) runScheduledAction(client mqtt.Client, scheduled *ScheduledAction) {
+	app.scheduleMutex.Lock()
+	current := app.scheduledAction == scheduled
+	if current {
+		app.scheduledAction = nil
+		app.scheduleTimer = nil
+	}
+	app.scheduleMutex.Unlock()
+	if !current {
+		return
+	}
+
+	// Forget the action before running it, a shutdown must not run again after the next start
+	app.saveScheduledAction(nil)
+	if client.IsConnected() {
+		app.updateScheduledAction(client)
+	}
+	log.Printf("Running scheduled %s", scheduled.Action)
+	app.runSystemAction(client, scheduled)
+}
+
+// saveScheduledAction writes the pending action to the schedule file, or removes the file when there is none
+func (app *Application) saveScheduledAction(scheduled *ScheduledAction) {
+	if scheduled == nil {
+		if err := os.Remove(app.scheduleFile); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove %s: %v", app.scheduleFile, err)
+		}
+		return
+	}
+
+	data, _ := json.Marshal(scheduled)
+	if err := os.WriteFile(app.scheduleFile, data, 0600); err != nil {
+		log.Printf("Failed to save scheduled action to %s: %v", app.scheduleFile, err)
+	}
+}
+
+// loadScheduledAction restores the action scheduled before the last restart
+func (app *Application) loadScheduledAction(client mqtt.Client) {
+	data, err := os.ReadFile(app.scheduleFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read %s: %v", app.scheduleFile, err)
+		}
+		return
+	}
+
+	var scheduled ScheduledAction
+	if err := json.Unmarshal(data, &scheduled); err != nil || systemActions[scheduled.Action] == nil {
+		log.Printf("Ignoring invalid scheduled action in %s", app.scheduleFile)
+		app.saveScheduledAction(nil)
+		return
+	}
+
+	// An action missed while mac2mqtt was not running is only caught up shortly afterwards
+	if time.Since(scheduled.At) > ScheduledActionGrace {
+		log.Printf("Dropping scheduled %s missed at %s", scheduled.Action, scheduled.At.Format(time.RFC3339))
+		app.saveScheduledAction(nil)
+		return
+	}
+	app.scheduleAction(client, &scheduled)
+}
+
+// updateScheduledAction publishes the pending scheduled action
+func (app *Application) updateScheduledAction(client mqtt.Client) {
+	app.scheduleMutex.Lock()
+	scheduled := app.scheduledAction
+	app.scheduleMutex.Unlock()
+
+	action, at := "none", "None"
+	attr := map[string]interface{}{}
+	if scheduled != nil {
+		action = scheduled.Action
+		at = scheduled.At.Format(time.RFC3339)
+		attr["at"] = at
+		attr["remaining"] = int(math.Max(0, math.Ceil(time.Until(scheduled.At).Seconds())))
+		if scheduled.DeferredSince != nil {
+			attr["deferred_since"] = scheduled.DeferredSince.Format(time.RFC3339)
+		}
+	}
+
+	attrJSON, _ := json.Marshal(attr)
+	client.Publish(app.getTopicPrefix()+"/status/scheduled_action/attr", 0, false, string(attrJSON))
+	client.Publish(app.getTopicPrefix()+"/status/scheduled_action/at", 0, false, at)
+	client.Publish(app.getTopicPrefix()+"/status/scheduled_action", 0, false, action)
+}
+
+// handleDisplayBrightnessCommand handles display brightness commands
+func (app *Application) handleDisplayBrightnessCommand(client mqtt.Client, topic, payload string) bool {
+	if !strings.HasPrefix(topic, app.getTopicPrefix()+"/command/display_") {
+		return false
+	}
+
+	// Check if we have any displays available
+	if len(app.displays) == 0 {
+		log.Printf("Received display brightness command but no displays are available")
+		log.Printf("Topic: %s, Payload: %s", topic, payload)
+		log.Println("This usually means BetterDisplay CLI is not installed or not accessible")
+		return true // Return true to indicate we handled the command
+	}
+
+	for _, display := range app.displays {
+		commandTopic := app.getTopicPrefix() + "/command/display_" + display.DisplayID + "_brightness"
+		if topic == commandTopic {
+			brightness, err := app.validateBrightnessInput(payload)
+			if err != nil {
+				log.Printf("Invalid brightness value for display %s: %v", display.Name, err)
+				return true
+			}
+
+			err = setDisplayBrightness(display.DisplayID, brightness)
+			if err != nil {
+				log.Printf("Error setting brightness for display %s: %v", display.Name, err)
+				// Check if it's a BetterDisplay CLI error
+				if !isBetterDisplayCLIAvailable() {
+					log.Println("BetterDisplay CLI is not available. Please install BetterDisplay and enable CLI access.")
+				}
+			} else {
+				// Update the status immediately
+				statusTopic := app.getTopicPrefix() + "/status/display_" + display.DisplayID + "_brightness"
+				client.Publish(statusTopic, 0, true, strconv.Itoa(brightness))
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// ShortcutRequest is the payload of the runshortcut command
+type ShortcutRequest struct {
+	ID        string `json:"id"`         // echoed back in the result
+	Name      string `json:"name"`       // shortcut name
+	Input     string `json:"input"`      // text passed to the shortcut as input
+	InputPath string `json:"input_path"` // file passed to the shortcut as input, must be under open.roots
+}
+
+// CommandResult is published after a shortcut or custom command finishes
+type CommandResult struct {
+	ID       string `json:"id,omitempty"`
+	Name     string `json:"name"`
+	Success  bool   `json:"success"`
+	ExitCode int    `json:"exit_code"`
+	Output   string `json:"output"`
+	Error    string `json:"error,omitempty"`
+}
+
+// getShortcuts lists the shortcuts of the current user
+func getShortcuts() ([]string, error) {
+	output, err := exec.Command("/usr/bin/shortcuts", "list").Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing shortcuts: %w", err)
+	}
+	return nonEmptyLines(string(output)), nil
+}
+
+// runShortcut runs the shortcut and returns its output
+func runShortcut(request *ShortcutRequest) *CommandResult {
+	result := &CommandResult{ID: request.ID, Name: request.Name}
+
+	tmpDir, err := os.MkdirTemp("", "mac2mqtt-shortcut")
+	if err != nil {
+		result.ExitCode = -1
+		result.Error = err.Error()
+		return result
+	}
+	defer os.RemoveAll(tmpDir)
+
+	args := []string{"run", request.Name, "--output-path", filepath.Join(tmpDir, "output"), "--output-type", "public.plain-text"}
+	inputPath := request.InputPath
+	if request.Input != "" {
+		inputPath = filepath.Join(tmpDir, "input.txt")
+		if err := os.WriteFile(inputPath, []byte(request.Input), 0600); err != nil {
+			result.ExitCode = -1
+			result.Error = err.Error()
+			return result
+		}
+	}
+	if inputPath != "" {
+		args = append(args, "--input-path", inputPath)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ShortcutTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "/usr/bin/shortcuts", args...)
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+
+	if output, readErr := os.ReadFile(filepath.Join(tmpDir, "output")); readErr == nil {
+		if len(output) > MaxCommandOutput {
+			output = output[:MaxCommandOutput]
+		}
+		result.Output = strings.TrimSpace(string(output))
+	}
+	if err != nil {
+		result.ExitCode = -1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			result.ExitCode = exitErr.ExitCode()
+		}
+		result.Error = strings.TrimSpace(stderr.String())
+		if result.Error == "" {
+			result.Error = err.Error()
+		}
+		return result
+	}
+	result.Success = true
+	return result
+}
+
+// runShortcutAsync runs the shortcut in the background and publishes the result
+func (app *Application) runShortcutAsync(client mqtt.Client, request *ShortcutRequest) {
+	go func() {
+		log.Printf("Running shortcut %s", request.Name)
+		result := runShortcut(request)
+		if !result.Success {
+			log.Printf("Shortcut %s failed with exit code %d: %s", request.Name, result.ExitCode, result.Error)
+		}
+		resultJSON, _ := json.Marshal(result)
+		client.Publish(app.getTopicPrefix()+"/status/shortcut/result", 0, false, string(resultJSON))
+	}()
+}
+
+// updateShortcuts publishes the list of shortcuts and refreshes the select options when it changes
+func (app *Application) updateShortcuts(client mqtt.Client) {
+	shortcuts, err := getShortcuts()
+	if err != nil {
+		log.Printf("Failed to get shortcuts: %v", err)
+		return
+	}
+
+	app.shortcutMutex.Lock()
+	changed := strings.Join(shortcuts, "\n") != strings.Join(app.shortcuts, "\n")
+	app.shortcuts = shortcuts
+	selected := app.selectedShortcut
+	app.shortcutMutex.Unlock()
+
+	if changed {
+		app.setDevice(client)
+	}
+
+	shortcutsJSON, _ := json.Marshal(shortcuts)
+	client.Publish(app.getTopicPrefix()+"/status/shortcuts", 0, false, string(shortcutsJSON))
+	if selected != "" {
+		client.Publish(app.getTopicPrefix()+"/status/shortcut/selected", 0, true, selected)
+	}
+}
+
+// handleShortcutCommand handles shortcut execution commands
+func (app *Application) handleShortcutCommand(client mqtt.Client, topic, payload string) bool {
+	switch topic {
+	case app.getTopicPrefix() + "/command/runshortcut":
+		request, err := app.validateShortcutInput(payload)
+		if err != nil {
+			log.Printf("Invalid shortcut: %v", err)
+			return true
+		}
+		app.runShortcutAsync(client, request)
+
+	case app.getTopicPrefix() + "/command/shortcut/select":
+		if _, err := app.validateShortcutInput(payload); err != nil {
+			log.Printf("Invalid shortcut: %v", err)
+			return true
+		}
+		app.shortcutMutex.Lock()
+		app.selectedShortcut = payload
+		app.shortcutMutex.Unlock()
+		client.Publish(app.getTopicPrefix()+"/status/shortcut/selected", 0, true, payload)
+
+	case app.getTopicPrefix() + "/command/shortcut/run":
+		app.shortcutMutex.Lock()
+		selected := app.selectedShortcut
+		app.shortcutMutex.Unlock()
+		if selected == "" {
+			log.Println("No shortcut selected")
+			return true
+		}
+		app.runShortcutAsync(client, &ShortcutRequest{Name: selected})
+
+	default:
+		return false
+	}
+	return true
+}
+
+// handleOpenCommand opens URLs, files and applications
+func (app *Application) handleOpenCommand(topic, payload string) bool {
+	if topic != app.getTopicPrefix()+"/command/open" {
+		return false
+	}
+
+	target, err := app.validateOpenInput(payload)
+	if err != nil {
+		log.Printf("Invalid open target: %v", err)
+		return true
+	}
+
+	var args []string
+	switch {
+	case target.URL != "":
+		args = []string{target.URL}
+	case target.Path != "":
+		args = []string{target.Path}
+	case target.App != "":
+		args = []string{"-a", target.App}
+	case target.BundleID != "":
+		args = []string{"-b", target.BundleID}
+	}
+
+	log.Printf("Opening %s", strings.Join(args, " "))
+	if output, err := exec.Command("/usr/bin/open", args...).CombinedOutput(); err != nil {
+		log.Printf("Error opening %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return true
+}
+
+// customParamRe matches {{param}} placeholders in custom command templates
+var customParamRe = regexp.MustCompile(`\{\{\s*([a-z0-9_]+)\s*\}\}`)
+
+// validateCustomCommands validates the custom command configuration
+func (app *Application) validateCustomCommands() error {
+	seen := make(map[string]bool)
+	nameRe := regexp.MustCompile(`^[a-z0-9_]+$`)
+	for i := range app.config.Commands {
+		command := &app.config.Commands[i]
+		if !nameRe.MatchString(command.Name) {
+			return fmt.Errorf("command name %q must only contain lowercase letters, digits and underscores", command.Name)
+		}
+		if seen[command.Name] {
+			return fmt.Errorf("duplicate command name %q", command.Name)
+		}
+		// "x_result" would take the Home Assistant key of the result sensor of command "x"
+		if strings.HasSuffix(command.Name, "_result") {
+			return fmt.Errorf("command name %q cannot end in _result", command.Name)
+		}
+		seen[command.Name] = true
+
+		if (len(command.Argv) == 0) == (command.AppleScript == "") {
+			return fmt.Errorf("command %s: exactly one of argv and applescript is required", command.Name)
+		}
+		if len(command.Argv) > 0 && !filepath.IsAbs(command.Argv[0]) {
+			return fmt.Errorf("command %s: argv must start with an absolute path", command.Name)
+		}
+		if command.Timeout < 1 {
+			return fmt.Errorf("command %s: timeout must be positive", command.Name)
+		}
+		if command.RunAs != "" && !regexp.MustCompile(`^[a-z_][a-z0-9_.-]*$`).MatchString(command.RunAs) {
+			return fmt.Errorf("command %s: invalid run_as user %q", command.Name, command.RunAs)
+		}
+
+		params := make(map[string]bool)
+		for j := range command.Params {
+			param := &command.Params[j]
+			if !nameRe.MatchString(param.Name) {
+				return fmt.Errorf("command %s: invalid parameter name %q", command.Name, param.Name)
+			}
+			params[param.Name] = true
+			if param.Pattern == "" {
+				// No leading dash, so a value can't be taken for an option
+				param.Pattern = `[A-Za-z0-9_.][A-Za-z0-9_.-]*`
+			}
+			// Values have to match the whole pattern, not just contain a match
+			re, err := regexp.Compile(`^(?:` + param.Pattern + `)$`)
+			if err != nil {
+				return fmt.Errorf("command %s: invalid pattern for parameter %s: %w", command.Name, param.Name, err)
+			}
+			param.re = re
+			if param.Default != "" && !re.MatchString(param.Default) {
+				return fmt.Errorf("command %s: default of parameter %s does not match its pattern", command.Name, param.Name)
+			}
+		}
+		for _, template := range append([]string{command.AppleScript}, command.Argv...) {
+			for _, match := range customParamRe.FindAllStringSubmatch(template, -1) {
+				if !params[match[1]] {
+					return fmt.Errorf("command %s: unknown parameter {{%s}}", command.Name, match[1])
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// customCommandArgs validates the payload parameters and returns the command line to run
+func customCommandArgs(command CustomCommand, payload string) ([]string, error) {
+	values := make(map[string]string)
+	if strings.TrimSpace(payload) != "" {
+		if err := json.Unmarshal([]byte(payload), &values); err != nil {
+			return nil, fmt.Errorf("parameters must be a JSON object of strings: %w", err)
+		}
+	}
+
+	params := make(map[string]string)
+	for _, param := range command.Params {
+		value, ok := values[param.Name]
+		if !ok {
+			if param.Default == "" && !param.Optional {
+				return nil, fmt.Errorf("parameter %s is required", param.Name)
+			}
+			value = param.Default
+		}
+		// An optional parameter may be sent empty to leave it out
+		if ok && !(value == "" && param.Optional) && !param.re.MatchString(value) {
+			return nil, fmt.Errorf("parameter %s does not match %s", param.Name, param.Pattern)
+		}
+		params[param.Name] = value
+		delete(values, param.Name)
+	}
+	for name := range values {
+		return nil, fmt.Errorf("unknown parameter %s", name)
+	}
+
+	// Each argv element is filled in on its own and never goes through a shell
+	var args []string
+	if command.AppleScript != "" {
+		script := customParamRe.ReplaceAllStringFunc(command.AppleScript, func(placeholder string) string {
+			return appleScriptString(params[customParamRe.FindStringSubmatch(placeholder)[1]])
+		})
+		args = []string{"/usr/bin/osascript", "-e", script}
+	} else {
+		for _, arg := range command.Argv {
+			args = append(args, customParamRe.ReplaceAllStringFunc(arg, func(placeholder string) string {
+				return params[customParamRe.FindStringSubmatch(placeholder)[1]]
+			}))
+		}
+	}
+
+	if command.RunAs != "" {
+		args = append([]string{"/usr/bin/sudo", "-n", "-u", command.RunAs, "--"}, args...)
+	}
+	return args, nil
+}
+
+// runCustomCommand runs a custom command and returns its output and exit code
+func runCustomCommand(command CustomCommand, args []string) *CommandResult {
+	result := &CommandResult{Name: command.Name}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(command.Timeout)*time.Second)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if len(output) > MaxCommandOutput {
+		output = output[:MaxCommandOutput]
+	}
+	result.Output = strings.TrimSpace(string(output))
+
+	if err != nil {
+		result.ExitCode = -1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			result.ExitCode = exitErr.ExitCode()
+		}
+		result.Error = err.Error()
+		if ctx.Err() == context.DeadlineExceeded {
+			result.Error = fmt.Sprintf("timed out after %d seconds", command.Timeout)
+		}
+		return result
+	}
+	result.Success = true
+	return result
+}
+
+// handleCustomCommand runs config-defined commands, MQTT only ever supplies validated parameter values
+func (app *Application) handleCustomCommand(client mqtt.Client, topic, payload string) bool {
+	prefix := app.getTopicPrefix() + "/command/custom/"
+	if !strings.HasPrefix(topic, prefix) {
+		return false
+	}
+
+	name := strings.TrimPrefix(topic, prefix)
+	for _, command := range app.config.Commands {
+		if command.Name != name {
+			continue
+		}
+
+		args, err := customCommandArgs(command, payload)
+		if err != nil {
+			log.Printf("Invalid parameters for command %s: %v", name, err)
+			return true
+		}
+
+		go func(command CustomCommand) {
+			log.Printf("Running custom command %s", command.Name)
+			result := runCustomCommand(command, args)
+			if !result.Success {
+				log.Printf("Custom command %s failed with exit code %d: %s", command.Name, result.ExitCode, result.Error)
+			}
+			resultJSON, _ := json.Marshal(result)
+			client.Publish(app.getTopicPrefix()+"/status/custom/"+command.Name+"/result", 0, false, string(resultJSON))
+		}(command)
+		return true
+	}
+
+	log.Printf("Unknown custom command: %s", name)
+	return true
+}
+
+// keepAwakeModes maps keep awake modes to caffeinate assertions
+var keepAwakeModes = map[string]string{
+	"display": "-d", // keep the display on
+	"idle":    "-i", // let the display sleep but keep the system awake
+	"system":  "-s", // keep the system awake even with the lid closed, only on AC power
+}
+
+// KeepAwakeRequest is the payload of the keep awake command
+type KeepAwakeRequest struct {
+	Enabled  bool          `json:"-"`
+	Duration time.Duration `json:"-"` // 0 keeps the Mac awake until turned off
+	Mode     string        `json:"mode"`
+	Reason   string        `json:"reason"`
+}
+
+// startKeepAwake replaces any caffeinate started by mac2mqtt with a new one for the request
+func (app *Application) startKeepAwake(client mqtt.Client, request *KeepAwakeRequest) error {
+	// Hold the lock for the whole swap so overlapping commands can't both leave a caffeinate running
+	app.keepAwakeMutex.Lock()
+	defer app.keepAwakeMutex.Unlock()
+	app.stopKeepAwakeLocked()
+
+	// -w ties caffeinate to this process so it can never outlive mac2mqtt
+	args := []string{keepAwakeModes[request.Mode], "-w", strconv.Itoa(os.Getpid())}
+	if request.Duration > 0 {
+		args = append(args, "-t", strconv.Itoa(int(math.Ceil(request.Duration.Seconds()))))
+	}
+	cmd := exec.Command("/usr/bin/caffeinate", args...)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start caffeinate: %w", err)
+	}
+
+	app.keepAwakeCmd = cmd
+	app.keepAwakeRequest = request
+	app.keepAwakeStarted = time.Now()
+	log.Printf("Keeping awake (mode %s, pid %d, duration %s, reason %q)", request.Mode, cmd.Process.Pid, request.Duration, request.Reason)
+
+	go func() {
+		_ = cmd.Wait()
+		app.keepAwakeMutex.Lock()
+		ended := app.keepAwakeCmd == cmd
+		if ended {
+			app.keepAwakeCmd = nil
+			app.keepAwakeRequest = nil
+		}
+		app.keepAwakeMutex.Unlock()
+
+		// Report timed keep awakes running out
+		if ended && client.IsConnected() {
+			app.updateCaffeinateStatus(client)
+		}
+	}()
+	return nil
+}
+
+// stopKeepAwake stops the caffeinate started by mac2mqtt, other caffeinate processes are left alone
+func (app *Application) stopKeepAwake() {
+	app.keepAwakeMutex.Lock()
+	defer app.keepAwakeMutex.Unlock()
+	app.stopKeepAwakeLocked()
+}
+
+// stopKeepAwakeLocked is stopKeepAwake for callers that already hold keepAwakeMutex
+func (app *Application) stopKeepAwakeLocked() {
+	cmd := app.keepAwakeCmd
+	app.keepAwakeCmd = nil
+	app.keepAwakeRequest = nil
+
+	if cmd != nil {
+		if err := cmd.Process.Kill(); err != nil {
+			log.Printf("Failed to stop caffeinate (pid %d): %v", cmd.Process.Pid, err)
+		}
+	}
+}
+
+// handleKeepAwakeCommand handles keep awake commands
+func (app *Application) handleKeepAwakeCommand(client mqtt.Client, topic, payload string) bool {
+	if topic != app.getTopicPrefix()+"/command/keepawake" {
+		return false
+	}
+
+	request, err := app.validateKeepAwakeInput(payload)
+	if err != nil {
+		log.Printf("Invalid keep awake value: %v", err)
+		return true
+	}
+
+	if request.Enabled {
+		if err := app.startKeepAwake(client, request); err != nil {
+			log.Printf("Error keeping awake: %v", err)
+		}
+	} else {
+		app.stopKeepAwake()
+	}
+	app.updateCaffeinateStatus(client)
+	return true
+}
+
+// handlePlayPauseCommand handles play/pause commands
+func (app *Application) handlePlayPauseCommand(client mqtt.Client, topic, payload string) bool {
+	if topic != app.getTopicPrefix()+"/command/playpause" {
+		return false
+	}
+
+	if payload == "playpause" {
+		commandPlayPause()
+		// Update the now playing sensor after a short delay to reflect the new state
+		time.Sleep(500 * time.Millisecond)
+		app.updateNowPlaying(client)
+	}
+	return true
+}
+
+// handleEjectCommand handles volume eject commands
+func (app *Application) handleEjectCommand(client mqtt.Client, topic, payload string) bool {
+	if topic != app.getTopicPrefix()+"/command/eject" {
+		return false
+	}
+
+	var volume *VolumeUsage
+	app.diskMutex.RLock()
+	for i := range app.diskVolumes {
+		if app.diskVolumes[i].Slug == payload {
+			v := app.diskVolumes[i]
+			volume = &v
+			break
+		}
+	}
+	app.diskMutex.RUnlock()
+
+	if volume == nil || !volume.isEjectable() {
+		log.Printf("Invalid eject volume: %s", payload)
+		return true
+	}
+
+	log.Printf("Ejecting volume %s", volume.Mount)
+	if err := ejectVolume(*volume); err != nil {
+		log.Printf("Error ejecting volume %s: %v", volume.Mount, err)
+		app.publishEvent(client, "volume", map[string]interface{}{
+			"event_type": "eject_failed",
+			"volume":     volume.Slug,
+			"mount":      volume.Mount,
+			"error":      err.Error(),
+		})
+		return true
+	}
+
+	app.publishVolumeEvent(client, "ejected", *volume)
+	app.checkVolumeChanges(client)
+	return true
+}
+
+// clampVolume limits a volume to the valid range
+func clampVolume(volume int) int {
+	if volume < MinVolume {
+		return MinVolume
+	}
+	if volume > MaxVolume {
+		return MaxVolume
+	}
+	return volume
+}
+
+// stopVolumeFade cancels a running fade, if any
+func (app *Application) stopVolumeFade() {
+	app.volumeFadeMutex.Lock()
+	defer app.volumeFadeMutex.Unlock()
+	if app.volumeFadeCancel != nil {
+		app.volumeFadeCancel()
+		app.volumeFadeCancel = nil
+	}
+}
+
+// replaceVolumeFade cancels a running fade and registers a new one under the same lock,
+// so overlapping fade commands can't both keep running
+func (app *Application) replaceVolumeFade() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	app.volumeFadeMutex.Lock()
+	defer app.volumeFadeMutex.Unlock()
+	if app.volumeFadeCancel != nil {
+		app.volumeFadeCancel()
+	}
+	app.volumeFadeCancel = cancel
+	return ctx, cancel
+}
+
+// forgetDuck drops the volume saved by duckVolume, so a later restore doesn't undo a volume set on purpose
+func (app *Application) forgetDuck() {
+	app.volumeFadeMutex.Lock()
+	defer app.volumeFadeMutex.Unlock()
+	if app.duckTimer != nil {
+		app.duckTimer.Stop()
+		app.duckTimer = nil
+	}
+	app.volumeBeforeDuck = nil
+}
+
+// fadeVolume moves the volume to target over duration in one percent steps, replacing any running fade
+func (app *Application) fadeVolume(client mqtt.Client, target int, duration time.Duration) {
+	ctx, cancel := app.replaceVolumeFade()
+
+	start, err := app.audioBackend.GetVolume()
+	if err != nil {
+		log.Printf("Failed to get volume with %s backend: %v", app.audioBackend.Name(), err)
+		cancel()
+		return
+	}
+	steps := target - start
+	if steps < 0 {
+		steps = -steps
+	}
+	if steps == 0 || duration <= 0 {
+		cancel()
+		if err := app.audioBackend.SetVolume(target); err != nil {
+			log.Printf("Failed to set volume with %s backend: %v", app.audioBackend.Name(), err)
+		}
+		app.updateVolume(client)
+		return
+	}
+
+	// Don't call the backend more often than it can keep up with
+	interval := duration / time.Duration(steps)
+	if interval < MinVolumeFadeInterval {
+		interval = MinVolumeFadeInterval
+	}
+
+	go func() {
+		defer cancel()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		began := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			progress := float64(time.Since(began)) / float64(duration)
+			if progress > 1 {
+				progress = 1
+			}
+			volume := start + int(math.Round(float64(target-start)*progress))
+			if err := app.audioBackend.SetVolume(volume); err != nil {
+				log.Printf("Volume fade stopped, failed to set volume: %v", err)
+				return
+			}
+			client.Publish(app.getTopicPrefix()+"/status/volume", 0, false, strconv.Itoa(volume))
+			if progress == 1 {
+				return
+			}
+		}
+	}()
+}
+
+// duckVolume lowers the volume to level until restoreVolume is called or duration passes
+func (app *Application) duckVolume(client mqtt.Client, level int, duration time.Duration) {
+	app.stopVolumeFade()
+
+	app.volumeFadeMutex.Lock()
+	defer app.volumeFadeMutex.Unlock()
+
+	if app.volumeBeforeDuck == nil {
+		volume, err := app.audioBackend.GetVolume()
+		if err != nil {
+			log.Printf("Failed to get volume with %s backend: %v", app.audioBackend.Name(), err)
+			return
+		}
+		app.volumeBeforeDuck = &volume
+	}
+
+	// Ducking never raises the volume
+	if level < *app.volumeBeforeDuck {
+		if err := app.audioBackend.SetVolume(level); err != nil {
+			log.Printf("Failed to duck volume: %v", err)
+		}
+	}
+
+	if app.duckTimer != nil {
+		app.duckTimer.Stop()
+		app.duckTimer = nil
+	}
+	if duration > 0 {
+		app.duckTimer = time.AfterFunc(duration, func() { app.restoreVolume(client) })
+	}
+	app.updateVolume(client)
+}
+
+// isVolumeDucked reports whether the volume is lowered and waiting for restoreVolume
+func (app *Application) isVolumeDucked() bool {
+	app.volumeFadeMutex.Lock()
+	defer app.volumeFadeMutex.Unlock()
+	return app.volumeBeforeDuck != nil
+}
+
+// restoreVolume sets the volume back to what it was before ducking
+func (app *Application) restoreVolume(client mqtt.Client) {
+	app.volumeFadeMutex.Lock()
+	defer app.volumeFadeMutex.Unlock()
+
+	if app.duckTimer != nil {
+		app.duckTimer.Stop()
+		app.duckTimer = nil
+	}
+	if app.volumeBeforeDuck == nil {
+		return
+	}
+	if err := app.audioBackend.SetVolume(*app.volumeBeforeDuck); err != nil {
+		log.Printf("Failed to restore volume: %v", err)
+	}
+	app.volumeBeforeDuck = nil
+	app.updateVolume(client)
+}
+
+// VolumeFade is the payload of the fade and duck commands
+type VolumeFade struct {
+	Volume   int `json:"volume"`   // target or ducked volume
+	Duration int `json:"duration"` // seconds to fade over, or to stay ducked for (0 until restored)
+}
+
+// handleVolumeRampCommand handles relative, fade and duck volume commands
+func (app *Application) handleVolumeRampCommand(client mqtt.Client, topic, payload string) bool {
+	prefix := app.getTopicPrefix() + "/command/volume/"
+	if !strings.HasPrefix(topic, prefix) {
+		return false
+	}
+
+	switch action := strings.TrimPrefix(topic, prefix); action {
+	case "up", "down":
+		step := app.config.Audio.VolumeStep
+		if payload != "" {
+			var err error
+			if step, err = strconv.Atoi(payload); err != nil || step <= 0 || step > MaxVolume {
+				log.Printf("Invalid volume step: %s", payload)
+				return true
+			}
+		}
+		if action == "down" {
+			step = -step
+		}
+
+		app.stopVolumeFade()
+		volume, err := app.audioBackend.GetVolume()
+		if err != nil {
+			log.Printf("Failed to get volume with %s backend: %v", app.audioBackend.Name(), err)
+			return true
+		}
+		if err := app.audioBackend.SetVolume(clampVolume(volume + step)); err != nil {
+			log.Printf("Failed to set volume with %s backend: %v", app.audioBackend.Name(), err)
+		}
+		app.updateVolume(client)
+
+	case "fade":
+		var fade VolumeFade
+		if err := json.Unmarshal([]byte(payload), &fade); err != nil {
+			log.Printf("Invalid volume fade payload, expected {\"volume\": 30, \"duration\": 60}: %v", err)
+			return true
+		}
+		if fade.Volume < MinVolume || fade.Volume > MaxVolume || fade.Duration < 0 {
+			log.Printf("Invalid volume fade: %s", payload)
+			return true
+		}
+		app.forgetDuck()
+		app.fadeVolume(client, fade.Volume, time.Duration(fade.Duration)*time.Second)
+
+	case "duck":
+		if payload == "restore" {
+			app.restoreVolume(client)
+			return true
+		}
+		fade := VolumeFade{Volume: app.config.Audio.DuckVolume}
+		if payload != "" {
+			if err := json.Unmarshal([]byte(payload), &fade); err != nil {
+				log.Printf("Invalid volume duck payload, expected {\"volume\": 20, \"duration\": 30} or restore: %v", err)
+				return true
+			}
+		}
+		if fade.Volume < MinVolume || fade.Volume > MaxVolume || fade.Duration < 0 {
+			log.Printf("Invalid volume duck: %s", payload)
+			return true
+		}
+		app.duckVolume(client, fade.Volume, time.Duration(fade.Duration)*time.Second)
+
+	default:
+		log.Printf("Unknown volume command: %s", action)
+	}
+	return true
+}
+
+// logAudioError logs a failed audio query unless it failed the same way last time, so a device without volume
+// control doesn't log on every update. A nil err marks the query as working again.
+func (app *Application) logAudioError(query string, err error) {
+	app.audioErrorsMutex.Lock()
+	defer app.audioErrorsMutex.Unlock()
+
+	last, failing := app.audioErrors[query]
+	if err == nil {
+		if failing {
+			log.Printf("Getting %s with %s backend works again", query, app.audioBackend.Name())
+			delete(app.audioErrors, query)
+		}
+		return
+	}
+	if failing && last == err.Error() {
+		return
+	}
+	if app.audioErrors == nil {
+		app.audioErrors = make(map[string]string)
+	}
+	app.audioErrors[query] = err.Error()
+	log.Printf("Failed to get %s with %s backend: %v", query, app.audioBackend.Name(), err)
+}
+
+func (app *Application) updateVolume(client mqtt.Client) {
+	volume, err := app.audioBackend.GetVolume()
+	app.logAudioError("volume", err)
+	if err != nil {
+		return
+	}
+	token := client.Publish(app.getTopicPrefix()+"/status/volume", 0, false, strconv.Itoa(volume))
+	token.Wait()
+}
+
+func (app *Application) updateMute(client mqtt.Client) {
+	mute, err := app.audioBackend.GetMute()
+	app.logAudioError("mute status", err)
+	if err != nil {
+		return
+	}
+	token := client.Publish(app.getTopicPrefix()+"/status/mute", 0, false, strconv.FormatBool(mute))
+	token.Wait()
+}
+
+// BatteryInfo holds battery and power source details
+type BatteryInfo struct {
+	Percent        int     `json:"percent"`         // Charge percent, -1 if no battery
+	State          string  `json:"state"`           // "charging", "discharging", "charged", "finishing charge", "not charging"
+	PowerSource    string  `json:"power_source"`    // "AC", "Battery" or "UPS"
+	TimeRemaining  int     `json:"time_remaining"`  // Minutes to empty/full, -1 if not estimated yet
+	CycleCount     int     `json:"cycle_count"`     // Charge cycles, -1 if unknown
+	Condition      string  `json:"condition"`       // "Normal" or "Service Recommended"
+	DesignCapacity int     `json:"design_capacity"` // mAh, 0 if unknown
+	MaxCapacity    int     `json:"max_capacity"`    // mAh, 0 if unknown
+	Temperature    float64 `json:"temperature"`     // Celsius, 0 if unknown
+	AdapterWatts   int     `json:"adapter_watts"`   // 0 if no adapter is connected
+}
+
+// HealthPercent returns the maximum capacity as a percentage of the design capacity
+func (b *BatteryInfo) HealthPercent() float64 {
+	if b.DesignCapacity <= 0 || b.MaxCapacity <= 0 {
+		return 0
+	}
+	return float64(b.MaxCapacity) / float64(b.DesignCapacity) * 100
+}
+
+// parsePmsetBatt parses the output of `pmset -g batt`
+func parsePmsetBatt(output string) *BatteryInfo {
+	// $ /usr/bin/pmset -g batt
+	// Now drawing from 'Battery Power'
+	//  -InternalBattery-0 (id=4653155)        100%; discharging; 20:00 remaining present: true
+
+	info := &BatteryInfo{Percent: -1, TimeRemaining: -1, CycleCount: -1}
+
+	if res := regexp.MustCompile(`Now drawing from '([^']+)'`).FindStringSubmatch(output); len(res) == 2 {
+		switch res[1] {
+		case "AC Power":
+			info.PowerSource = "AC"
+		case "Battery Power":
+			info.PowerSource = "Battery"
+		case "UPS Power":
+			info.PowerSource = "UPS"
+		default:
+			info.PowerSource = res[1]
+		}
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.Contains(line, "InternalBattery") {
+			continue
+		}
+
+		if res := regexp.MustCompile(`(\d+)%`).FindStringSubmatch(line); len(res) == 2 {
+			info.Percent, _ = strconv.Atoi(res[1])
+		}
+
+		fields := strings.Split(line, ";")
+		if len(fields) > 1 {
+			state := strings.TrimSpace(fields[1])
+			if state == "AC attached" {
+				state = "not charging"
+			}
+			info.State = state
+		}
+
+		if res := regexp.MustCompile(`(\d+):(\d{2}) remaining`).FindStringSubmatch(line); len(res) == 3 {
+			hours, _ := strconv.Atoi(res[1])
+			minutes, _ := strconv.Atoi(res[2])
+			info.TimeRemaining = hours*60 + minutes
+		}
+		break
+	}
+
+	return info
+}
+
+// parseIoregBattery parses the output of `ioreg -rn AppleSmartBattery` into info
+func parseIoregBattery(output string, info *BatteryInfo) {
+	// $ /usr/sbin/ioreg -rn AppleSmartBattery
+	//   "CycleCount" = 87
+	//   "DesignCapacity" = 4382
+	//   "AppleRawMaxCapacity" = 4101
+	//   "Temperature" = 3012
+	//   "AdapterDetails" = {"Watts"=96,"Name"="96W USB-C Power Adapter",...}
+
+	values := map[string]string{}
+	re := regexp.MustCompile(`(?m)^\s*"(\w+)" = (.+)$`)
+	for _, match := range re.FindAllStringSubmatch(output, -1) {
+		values[match[1]] = strings.TrimSpace(match[2])
+	}
+
+	if v, err := strconv.Atoi(values["CycleCount"]); err == nil {
+		info.CycleCount = v
+	}
+	if v, err := strconv.Atoi(values["DesignCapacity"]); err == nil {
+		info.DesignCapacity = v
+	}
+	// On Apple Silicon MaxCapacity is a percentage and the mAh value lives in AppleRawMaxCapacity
+	if v, err := strconv.Atoi(values["AppleRawMaxCapacity"]); err == nil {
+		info.MaxCapacity = v
+	} else if v, err := strconv.Atoi(values["MaxCapacity"]); err == nil && v > 100 {
+		info.MaxCapacity = v
+	}
+	if v, err := strconv.Atoi(values["Temperature"]); err == nil {
+		info.Temperature = float64(v) / 100
+	}
+	if res := regexp.MustCompile(`"Watts"=(\d+)`).FindStringSubmatch(values["AdapterDetails"]); len(res) == 2 {
+		info.AdapterWatts, _ = strconv.Atoi(res[1])
+	}
+	if values["ExternalConnected"] == "No" {
+		info.AdapterWatts = 0
+	}
+
+	info.Condition = "Normal"
+	if failure, ok := values["PermanentFailureStatus"]; ok && failure != "0" {
+		info.Condition = "Service Recommended"
+	} else if health := info.HealthPercent(); health > 0 && health < 80 {
+		info.Condition = "Service Recommended"
+	}
+}
+
+// getBatteryInfo collects battery telemetry from pmset and the AppleSmartBattery registry entry
+func getBatteryInfo() (*BatteryInfo, error) {
+	output, err := exec.Command("/usr/bin/pmset", "-g", "batt").Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running pmset: %w", err)
+	}
+	info := parsePmsetBatt(string(output))
+	if info.Percent < 0 {
+		// No internal battery (desktop Mac), only the power source is meaningful
+		return info, nil
+	}
+
+	output, err = exec.Command("/usr/sbin/ioreg", "-rn", "AppleSmartBattery").Output()
+	if err != nil {
+		return info, fmt.Errorf("error running ioreg: %w", err)
+	}
+	parseIoregBattery(string(output), info)
+
+	return info, nil
+}
+
+// DiskUsage holds disk usage statistics
+type DiskUsage struct {
+	Total       uint64  `json:"total"`        // Total bytes
+	Used        uint64  `json:"used"`         // Used bytes
+	Free        uint64  `json:"free"`         // Free bytes
+	UsedPercent float64 `json:"used_percent"` // Used percentage
+	FreePercent float64 `json:"free_percent"` // Free percentage
+}
+
+func getDiskUsage() (*DiskUsage, error) {
+	fs := sigar.FileSystemList{}
+	if err := fs.Get(); err != nil {
+		return nil, fmt.Errorf("failed to get filesystem list: %w", err)
+	}
+
+	// Find the root filesystem
+	for _, filesystem := range fs.List {
+		if filesystem.DirName == "/" {
+			return getFileSystemUsage(filesystem.DirName)
+		}
+	}
+
+	return nil, fmt.Errorf("root filesystem not found")
+}
+
+// getFileSystemUsage returns the disk usage of the filesystem mounted at dirName
+func getFileSystemUsage(dirName string) (*DiskUsage, error) {
+	usage := sigar.FileSystemUsage{}
+	if err := usage.Get(dirName); err != nil {
+		return nil, fmt.Errorf("failed to get disk usage: %w", err)
+	}
+
+	// Convert from KB to bytes (gosigar returns values in KB)
+	totalBytes := usage.Total * 1024
+	usedBytes := usage.Used * 1024
+	freeBytes := usage.Free * 1024
+
+	// Calculate percentages
+	usedPercent := float64(0)
+	freePercent := float64(0)
+	if totalBytes > 0 {
+		usedPercent = float64(usedBytes) / float64(totalBytes) * 100
+		freePercent = float64(freeBytes) / float64(totalBytes) * 100
+	}
+
+	return &DiskUsage{
+		Total:       totalBytes,
+		Used:        usedBytes,
+		Free:        freeBytes,
+		UsedPercent: usedPercent,
+		FreePercent: freePercent,
+	}, nil
+}
+
+// VolumeUsage holds disk usage statistics for a single mounted volume
+type VolumeUsage struct {
+	DiskUsage
+	Slug    string `json:"slug"`    // Stable identifier derived from the mount point
+	Name    string `json:"name"`    // Display name
+	Mount   string `json:"mount"`   // Mount point
+	Type    string `json:"type"`    // Filesystem type, e.g. apfs, smbfs
+	Device  string `json:"device"`  // Device or share, e.g. /dev/disk4s1
+	Network bool   `json:"network"` // Mounted over the network
+}
+
+// pseudoFileSystems are never reported as volumes
+var pseudoFileSystems = map[string]bool{
+	"devfs":  true,
+	"autofs": true,
+	"nullfs": true,
+}
+
+// networkFileSystems are reported with network set
+var networkFileSystems = map[string]bool{
+	"smbfs":  true,
+	"nfs":    true,
+	"afpfs":  true,
+	"webdav": true,
+}
+
+// matchPatterns reports whether name matches one of the glob patterns
+func matchPatterns(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// volumeSlug returns a stable identifier for a mount point usable in topics and unique ids
+func volumeSlug(mount string) string {
+	if mount == "/" {
+		return "root"
+	}
+	slug := strings.ToLower(strings.TrimPrefix(mount, "/Volumes/"))
+	slug = regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(slug, "_")
+	return strings.Trim(slug, "_")
+}
+
+// volumeName returns a display name for a mount point
+func volumeName(mount string) string {
+	if mount == "/" {
+		return "Root"
+	}
+	return filepath.Base(mount)
+}
+
+// listMountedVolumes returns every mounted volume selected by cfg without querying its usage
+func listMountedVolumes(cfg DiskConfig) ([]VolumeUsage, error) {
+	fs := sigar.FileSystemList{}
+	if err := fs.Get(); err != nil {
+		return nil, fmt.Errorf("failed to get filesystem list: %w", err)
+	}
+
+	var volumes []VolumeUsage
+	seen := make(map[string]bool)
+	for _, filesystem := range fs.List {
+		if pseudoFileSystems[filesystem.SysTypeName] {
+			continue
+		}
+		if !matchPatterns(filesystem.DirName, cfg.Include) || matchPatterns(filesystem.DirName, cfg.Exclude) {
+			continue
+		}
+
+		slug := volumeSlug(filesystem.DirName)
+		if slug == "" {
+			continue
+		}
+		// "/Volumes/My Disk" and "/Volumes/My-Disk" share a slug, number the later ones
+		if seen[slug] {
+			base := slug
+			for i := 2; seen[slug]; i++ {
+				slug = fmt.Sprintf("%s_%d", base, i)
+			}
+			log.Printf("Volume %s has the same slug as another volume, using %s", filesystem.DirName, slug)
+		}
+		seen[slug] = true
+
+		volumes = append(volumes, VolumeUsage{
+			Slug:    slug,
+			Name:    volumeName(filesystem.DirName),
+			Mount:   filesystem.DirName,
+			Type:    filesystem.SysTypeName,
+			Device:  filesystem.DevName,
+			Network: networkFileSystems[filesystem.SysTypeName],
+		})
+	}
+
+	return volumes, nil
+}
+
+// errDiskUsagePending is returned while an earlier usage query of the volume has not returned yet
+var errDiskUsagePending = errors.New("earlier disk usage query has not returned yet")
+
+// pendingDiskUsage holds the mount points with a usage query that timed out and is still blocked
+var (
+	pendingDiskUsage      = make(map[string]bool)
+	pendingDiskUsageMutex sync.Mutex
+)
+
+// getFileSystemUsageWithTimeout is getFileSystemUsage giving up after timeout. A query that blocks keeps running in
+// the background and the volume is skipped until it returns, so a dead mount never piles up goroutines.
+func getFileSystemUsageWithTimeout(dirName string, timeout time.Duration) (*DiskUsage, error) {
+	pendingDiskUsageMutex.Lock()
+	if pendingDiskUsage[dirName] {
+		pendingDiskUsageMutex.Unlock()
+		return nil, errDiskUsagePending
+	}
+	pendingDiskUsage[dirName] = true
+	pendingDiskUsageMutex.Unlock()
+
+	type result struct {
+		usage *DiskUsage
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		usage, err := getFileSystemUsage(dirName)
+		pendingDiskUsageMutex.Lock()
+		delete(pendingDiskUsage, dirName)
+		pendingDiskUsageMutex.Unlock()
+		done <- result{usage, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.usage, r.err
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("disk usage query timed out after %s", timeout)
+	}
+}
+
+// getVolumeUsages returns the usage of every mounted volume selected by cfg
+func getVolumeUsages(cfg DiskConfig) ([]VolumeUsage, error) {
+	mounted, err := listMountedVolumes(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var volumes []VolumeUsage
+	for _, volume := range mounted {
+		usage, err := getFileSystemUsageWithTimeout(volume.Mount, DiskUsageTimeout)
+		if err != nil {
+			// Already logged when the query timed out
+			if !errors.Is(err, errDiskUsagePending) {
+				log.Printf("Failed to get disk usage for %s: %v", volume.Mount, err)
+			}
+			continue
+		}
+		if usage.Total == 0 {
+			continue
+		}
+		volume.DiskUsage = *usage
+		volumes = append(volumes, volume)
+	}
+
+	return volumes, nil
+}
+
+// isEjectable reports whether a volume is a removable or network volume that may be ejected
+func (v *VolumeUsage) isEjectable() bool {
+	return strings.HasPrefix(v.Mount, "/Volumes/")
+}
+
+// ejectVolume ejects a local volume or unmounts a network volume using diskutil
+func ejectVolume(volume VolumeUsage) error {
+	verb := "eject"
+	if volume.Network {
+		verb = "unmount"
+	}
+	output, err := exec.Command("/usr/sbin/diskutil", verb, volume.Mount).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("diskutil %s %s failed: %v: %s", verb, volume.Mount, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// CPUUsage holds CPU usage statistics
+type CPUUsage struct {
+	UsedPercent   float64 `json:"used_percent"`   // CPU used percentage
+	FreePercent   float64 `json:"free_percent"`   // CPU idle/free percentage
+	UserPercent   float64 `json:"user_percent"`   // CPU user (including nice) percentage
+	SystemPercent float64 `json:"system_percent"` // CPU system (including interrupts) percentage
+	IowaitPercent float64 `json:"iowait_percent"` // CPU waiting on I/O percentage
+}
+
+// MemoryUsage holds memory usage statistics
+type MemoryUsage struct {
+	Total       uint64  `json:"total"`        // Total bytes
+	Used        uint64  `json:"used"`         // Used bytes
+	Free        uint64  `json:"free"`         // Free bytes
+	UsedPercent float64 `json:"used_percent"` // Used percentage
+	FreePercent float64 `json:"free_percent"` // Free percentage
+}
+
+// ThermalInfo holds temperatures, fan speeds and the thermal pressure level
+type ThermalInfo struct {
+	Pressure       string    `json:"pressure"`        // "nominal", "moderate", "heavy", "trapping" or "sleeping", empty if unknown
+	CPUTemperature *float64  `json:"cpu_temperature"` // CPU die temperature in °C
+	GPUTemperature *float64  `json:"gpu_temperature"` // GPU die temperature in °C
+	Fans           []float64 `json:"fans"`            // fan speeds in RPM
+}
+
+// thermalPressureLevels are the thermal pressure levels reported by powermetrics
+var thermalPressureLevels = []string{"nominal", "moderate", "heavy", "trapping", "sleeping"}
+
+// ThermalBackend reads thermal information from a system tool
+type ThermalBackend interface {
+	Name() string
+	Read() (*ThermalInfo, error)
+}
+
+// parsePowermetricsThermal parses the output of `powermetrics --samplers smc,thermal -n 1`
+func parsePowermetricsThermal(output string) *ThermalInfo {
+	// **** SMC sensors ****
+	//
+	// CPU Thermal level: 0
+	// Fan: 1796.56 rpm
+	// CPU die temperature: 52.34 C
+	// GPU die temperature: 48.00 C
+	//
+	// **** Thermal pressure ****
+	//
+	// Current pressure level: Nominal
+
+	info := &ThermalInfo{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Current pressure level":
+			info.Pressure = strings.ToLower(value)
+		case "CPU die temperature", "GPU die temperature":
+			temperature, err := strconv.ParseFloat(strings.TrimSuffix(value, " C"), 64)
+			if err != nil {
+				continue
+			}
+			if key == "CPU die temperature" {
+				info.CPUTemperature = &temperature
+			} else {
+				info.GPUTemperature = &temperature
+			}
+		case "Fan":
+			if rpm, err := strconv.ParseFloat(strings.TrimSuffix(value, " rpm"), 64); err == nil {
+				info.Fans = append(info.Fans, rpm)
+			}
+		}
+	}
+	return info
+}
+
+// powermetricsThermalBackend reads thermal information with powermetrics, which needs root
+type powermetricsThermalBackend struct {
+	samplers string
+}
+
+func (b *powermetricsThermalBackend) Name() string { return "powermetrics" }
+
+func (b *powermetricsThermalBackend) Read() (*ThermalInfo, error) {
+	output, err := exec.Command("/usr/bin/powermetrics", "--samplers", b.samplers, "-n", "1", "-i", "1000").Output()
+	if err != nil && b.samplers != "thermal" {
+		// Apple Silicon has no smc sampler, only report the thermal pressure there
+		log.Printf("powermetrics smc sampler unavailable, falling back to thermal only: %v", err)
+		b.samplers = "thermal"
+		return b.Read()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error running powermetrics: %w", err)
+	}
+	return parsePowermetricsThermal(string(output)), nil
+}
+
+// parseSMCKeys parses the output of `smc -l` into numeric key values
+func parseSMCKeys(output string) map[string]float64 {
+	//   F0Ac  [fpe2]  1796 (bytes 1c 10)
+	//   FNum  [ui8 ]  1 (bytes 01)
+	//   TC0P  [sp78]  52.3 (bytes 34 4c)
+	//   Tp01  [flt ]  48.21875 (bytes 00 e0 40 42)
+
+	values := make(map[string]float64)
+	re := regexp.MustCompile(`^\s*(\S{4})\s+\[[^\]]+\]\s+(-?\d+(?:\.\d+)?)\b`)
+	for _, line := range strings.Split(output, "\n") {
+		match := re.FindStringSubmatch(line)
+		if len(match) != 3 {
+			continue
+		}
+		if value, err := strconv.ParseFloat(match[2], 64); err == nil {
+			values[match[1]] = value
+		}
+	}
+	return values
+}
+
+// smcTemperature returns the first known key, or the hottest key with the prefix on Apple Silicon
+func smcTemperature(values map[string]float64, keys []string, prefix string) *float64 {
+	for _, key := range keys {
+		if value, ok := values[key]; ok && value > 0 {
+			return &value
+		}
+	}
+
+	var hottest *float64
+	for key, value := range values {
+		if strings.HasPrefix(key, prefix) && value > 0 && (hottest == nil || value > *hottest) {
+			v := value
+			hottest = &v
+		}
+	}
+	return hottest
+}
+
+// thermalInfoFromSMC picks the CPU, GPU and fan values out of the SMC keys
+func thermalInfoFromSMC(values map[string]float64) *ThermalInfo {
+	info := &ThermalInfo{
+		CPUTemperature: smcTemperature(values, []string{"TC0P", "TC0D", "TC0E", "TC0F"}, "Tp"),
+		GPUTemperature: smcTemperature(values, []string{"TG0P", "TG0D"}, "Tg"),
+	}
+	for i := 0; i < int(values["FNum"]); i++ {
+		info.Fans = append(info.Fans, values[fmt.Sprintf("F%dAc", i)])
+	}
+	return info
+}
+
+// smcThermalBackend reads thermal information with the smc tool from smcFanControl
+type smcThermalBackend struct {
+	path string
+}
+
+func (b *smcThermalBackend) Name() string { return "smc" }
+
+func (b *smcThermalBackend) Read() (*ThermalInfo, error) {
+	output, err := exec.Command(b.path, "-l").Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running %s: %w", b.path, err)
+	}
+	return thermalInfoFromSMC(parseSMCKeys(string(output))), nil
+}
+
+// newThermalBackend creates the configured thermal backend, nil when thermal sensors are disabled
+func newThermalBackend(cfg ThermalConfig) (ThermalBackend, error) {
+	switch cfg.Backend {
+	case "powermetrics":
+		return &powermetricsThermalBackend{samplers: "smc,thermal"}, nil
+	case "smc":
+		return &smcThermalBackend{path: cfg.SMCPath}, nil
+	case "":
+		// Pick whatever works without extra configuration
+		if os.Getuid() == 0 {
+			return &powermetricsThermalBackend{samplers: "smc,thermal"}, nil
+		}
+		if _, err := os.Stat(cfg.SMCPath); err == nil {
+			return &smcThermalBackend{path: cfg.SMCPath}, nil
+		}
+		log.Println("Thermal sensors disabled: run as root for powermetrics or install the smc tool")
+		return nil, nil
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown thermal backend %q", cfg.Backend)
+	}
+}
+
+func (app *Application) updateThermal(client mqtt.Client) {
+	if app.thermalBackend == nil {
+		return
+	}
+
+	info, err := app.thermalBackend.Read()
+	if err != nil {
+		log.Printf("Failed to read thermal info from %s: %v", app.thermalBackend.Name(), err)
+		return
+	}
+
+	app.thermalMutex.Lock()
+	changed := app.thermalInfo == nil ||
+		len(app.thermalInfo.Fans) != len(info.Fans) ||
+		(app.thermalInfo.Pressure == "") != (info.Pressure == "") ||
+		(app.thermalInfo.CPUTemperature == nil) != (info.CPUTemperature == nil) ||
+		(app.thermalInfo.GPUTemperature == nil) != (info.GPUTemperature == nil)
+	app.thermalInfo = info
+	app.thermalMutex.Unlock()
+
+	// Refresh discovery when the backend reports a different set of sensors
+	if changed {
+		app.setDevice(client)
+	}
+
+	prefix := app.getTopicPrefix() + "/status/thermal"
+	if info.Pressure != "" {
+		client.Publish(prefix+"/pressure", 0, false, info.Pressure)
+	}
+	if info.CPUTemperature != nil {
+		client.Publish(prefix+"/cpu_temperature", 0, false, fmt.Sprintf("%.1f", *info.CPUTemperature))
+		app.evaluateAlerts(client, "cpu_temperature", *info.CPUTemperature)
+	}
+	if info.GPUTemperature != nil {
+		client.Publish(prefix+"/gpu_temperature", 0, false, fmt.Sprintf("%.1f", *info.GPUTemperature))
+	}
+	for i, rpm := range info.Fans {
+		client.Publish(fmt.Sprintf("%s/fan/%d/rpm", prefix, i), 0, false, fmt.Sprintf("%.0f", rpm))
+	}
+}
+
+// UptimeInfo holds system uptime information
+type UptimeInfo struct {
+	Seconds uint64 `json:"seconds"` // Uptime in seconds
+	Human   string `json:"human"`   // Human-readable format
+}
+
+func (app *Application) getCPUUsage() (*CPUUsage, error) {
+	cpu := sigar.Cpu{}
+	if err := cpu.Get(); err != nil {
+		return nil, fmt.Errorf("failed to get CPU stats: %w", err)
+	}
+
+	app.cpuMutex.Lock()
+	defer app.cpuMutex.Unlock()
+
+	usage := cpuUsageBetween(app.lastCPU, cpu)
+
+	// Store current CPU stats for next calculation
+	app.lastCPU = cpu
+
+	return usage, nil
+}
+
+// cpuUsageBetween calculates CPU usage from two consecutive measurements
+func cpuUsageBetween(last, cpu sigar.Cpu) *CPUUsage {
+	// Calculate the delta since last measurement
+	userDelta := cpu.User - last.User
+	sysDelta := cpu.Sys - last.Sys
+	idleDelta := cpu.Idle - last.Idle
+	waitDelta := cpu.Wait - last.Wait
+	niceDelta := cpu.Nice - last.Nice
+	irqDelta := cpu.Irq - last.Irq
+	softIrqDelta := cpu.SoftIrq - last.SoftIrq
+	stolenDelta := cpu.Stolen - last.Stolen
+
+	// Calculate total time delta
+	totalDelta := userDelta + sysDelta + idleDelta + waitDelta + niceDelta + irqDelta + softIrqDelta + stolenDelta
+
+	// If this is the first measurement or total is zero, return 0% usage
+	if totalDelta == 0 {
+		return &CPUUsage{
+			UsedPercent: 0,
+			FreePercent: 100,
+		}
+	}
+
+	// Calculate idle and used percentages
+	idlePercent := float64(idleDelta) / float64(totalDelta) * 100
+	usedPercent := 100 - idlePercent
+
+	return &CPUUsage{
+		UsedPercent:   usedPercent,
+		FreePercent:   idlePercent,
+		UserPercent:   float64(userDelta+niceDelta) / float64(totalDelta) * 100,
+		SystemPercent: float64(sysDelta+irqDelta+softIrqDelta) / float64(totalDelta) * 100,
+		IowaitPercent: float64(waitDelta) / float64(totalDelta) * 100,
+	}
+}
+
+// getPerCoreUsage returns the usage of each logical core since the last call
+func (app *Application) getPerCoreUsage() ([]*CPUUsage, error) {
+	cpus := sigar.CpuList{}
+	if err := cpus.Get(); err != nil {
+		return nil, fmt.Errorf("failed to get per-core CPU stats: %w", err)
+	}
+
+	app.cpuMutex.Lock()
+	defer app.cpuMutex.Unlock()
+
+	usages := make([]*CPUUsage, len(cpus.List))
+	for i, cpu := range cpus.List {
+		last := sigar.Cpu{}
+		if i < len(app.lastCPUList.List) {
+			last = app.lastCPUList.List[i]
+		}
+		usages[i] = cpuUsageBetween(last, cpu)
+	}
+
+	// Store current CPU stats for next calculation
+	app.lastCPUList = cpus
+
+	return usages, nil
+}
+
+// CPUTopology holds static information about the processor
+type CPUTopology struct {
+	PerformanceCores int // logical performance cores, 0 if the cores are not split
+	EfficiencyCores  int // logical efficiency cores, numbered before the performance cores
+	FrequencyMHz     int // nominal frequency, 0 if not reported (Apple Silicon)
+}
+
+// sysctlInt reads an integer sysctl value
+func sysctlInt(name string) (int, error) {
+	output, err := exec.Command("/usr/sbin/sysctl", "-n", name).Output()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(output)))
+}
+
+// getCPUTopology reads the core split and frequency using sysctl
+func getCPUTopology() CPUTopology {
+	topology := CPUTopology{}
+
+	// Apple Silicon reports performance levels, perflevel0 being the performance cores
+	if cores, err := sysctlInt("hw.nperflevels"); err == nil && cores > 1 {
+		topology.PerformanceCores, _ = sysctlInt("hw.perflevel0.logicalcpu")
+		topology.EfficiencyCores, _ = sysctlInt("hw.perflevel1.logicalcpu")
+	}
+
+	// Only Intel Macs report their frequency
+	if hz, err := sysctlInt("hw.cpufrequency"); err == nil {
+		topology.FrequencyMHz = hz / 1000000
+	}
+
+	return topology
+}
+
+func getMemoryUsage() (*MemoryUsage, error) {
+	vmStat, err := mem.VirtualMemory()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get virtual memory stats: %w", err)
+	}
+
+	total := vmStat.Total
+	used := vmStat.Used
+	free := vmStat.Free
+
+	return &MemoryUsage{
+		Total:       total,
+		Used:        used,
+		Free:        free,
+		UsedPercent: vmStat.UsedPercent,
+		FreePercent: 100 - vmStat.UsedPercent,
+	}, nil
+}
 
-			// Parse the JSON line from the stream
-			var mediaData map[string]interface{}
-			if err := json.Unmarshal([]byte(line), &mediaData); err != nil {
-				log.Printf("Error parsing media stream JSON: %v", err)
-				continue
-			}
+// MemoryDetails holds macOS specific memory statistics
+type MemoryDetails struct {
+	SwapTotal       uint64  `json:"swap_total"`        // Total swap bytes
+	SwapUsed        uint64  `json:"swap_used"`         // Used swap bytes
+	SwapUsedPercent float64 `json:"swap_used_percent"` // Used swap percentage
+	Compressed      uint64  `json:"compressed"`        // Bytes occupied by the compressor
+	Wired           uint64  `json:"wired"`             // Wired bytes
+	Active          uint64  `json:"active"`            // Active bytes
+	Inactive        uint64  `json:"inactive"`          // Inactive bytes
+	Pressure        string  `json:"pressure"`          // "normal", "warn" or "critical"
+}
 
-			// Process the media update only if MQTT client is connected
-			if client.IsConnected() {
-				app.processMediaStreamUpdate(client, mediaData)
+// memoryPressureLevels maps kern.memorystatus_vm_pressure_level to a name
+var memoryPressureLevels = map[int]string{
+	1: "normal",
+	2: "warn",
+	4: "critical",
+}
+
+// parseVMStat parses the output of `vm_stat` into page counts and the page size
+func parseVMStat(output string) (map[string]uint64, uint64, error) {
+	// Mach Virtual Memory Statistics: (page size of 16384 bytes)
+	// Pages free:                               12345.
+	// Pages wired down:                         98765.
+	// Pages occupied by compressor:             54321.
+
+	res := regexp.MustCompile(`page size of (\d+) bytes`).FindStringSubmatch(output)
+	if len(res) != 2 {
+		return nil, 0, fmt.Errorf("page size not found in vm_stat output")
+	}
+	pageSize, _ := strconv.ParseUint(res[1], 10, 64)
+
+	pages := make(map[string]uint64)
+	for _, match := range regexp.MustCompile(`(?m)^"?([^:"]+)"?:\s+(\d+)\.?$`).FindAllStringSubmatch(output, -1) {
+		pages[match[1]], _ = strconv.ParseUint(match[2], 10, 64)
+	}
+	return pages, pageSize, nil
+}
+
+func getMemoryDetails() (*MemoryDetails, error) {
+	details := &MemoryDetails{}
+
+	swap, err := mem.SwapMemory()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get swap stats: %w", err)
+	}
+	details.SwapTotal = swap.Total
+	details.SwapUsed = swap.Used
+	details.SwapUsedPercent = swap.UsedPercent
+
+	output, err := exec.Command("/usr/bin/vm_stat").Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running vm_stat: %w", err)
+	}
+	pages, pageSize, err := parseVMStat(string(output))
+	if err != nil {
+		return nil, err
+	}
+	details.Compressed = pages["Pages occupied by compressor"] * pageSize
+	details.Wired = pages["Pages wired down"] * pageSize
+	details.Active = pages["Pages active"] * pageSize
+	details.Inactive = pages["Pages inactive"] * pageSize
+
+	level, err := sysctlInt("kern.memorystatus_vm_pressure_level")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get memory pressure level: %w", err)
+	}
+	details.Pressure = memoryPressureLevels[level]
+	if details.Pressure == "" {
+		details.Pressure = "normal"
+	}
+
+	return details, nil
+}
+
+// ProcessInfo describes a running process for the top processes sensors
+type ProcessInfo struct {
+	Name          string  `json:"name"`
+	PID           int32   `json:"pid"`
+	CPUPercent    float64 `json:"cpu_percent"`    // percent of one core since the last sample
+	MemoryPercent float64 `json:"memory_percent"` // percent of physical memory
+	RSS           uint64  `json:"rss"`            // resident memory in bytes
+}
+
+// sampleProcesses returns every running process with its CPU usage since the last call
+func (app *Application) sampleProcesses() ([]ProcessInfo, error) {
+	procs, err := process.Processes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes: %w", err)
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(app.lastProcTime).Seconds()
+	cpuTimes := make(map[int32]float64, len(procs))
+	infos := make([]ProcessInfo, 0, len(procs))
+	for _, p := range procs {
+		// Processes can exit or be inaccessible while we walk the list, skip them
+		name, err := p.Name()
+		if err != nil {
+			continue
+		}
+		memInfo, err := p.MemoryInfo()
+		if err != nil {
+			continue
+		}
+		info := ProcessInfo{Name: name, PID: p.Pid, RSS: memInfo.RSS}
+		if memPercent, err := p.MemoryPercent(); err == nil {
+			info.MemoryPercent = float64(memPercent)
+		}
+		if times, err := p.Times(); err == nil {
+			cpuTimes[p.Pid] = times.User + times.System
+			if last, ok := app.lastProcCPU[p.Pid]; ok && elapsed > 0 && cpuTimes[p.Pid] >= last {
+				info.CPUPercent = (cpuTimes[p.Pid] - last) / elapsed * 100
 			}
 		}
+		infos = append(infos, info)
+	}
 
-		if err := scanner.Err(); err != nil {
-			log.Printf("Error reading media stream: %v", err)
-			log.Println("Media stream will restart on next application restart")
-		}
-	}()
+	app.lastProcCPU = cpuTimes
+	app.lastProcTime = now
+	return infos, nil
+}
 
-	log.Println("Media stream started successfully")
+// topProcesses returns the first count processes ordered by less
+func topProcesses(procs []ProcessInfo, count int, less func(a, b ProcessInfo) bool) []ProcessInfo {
+	sorted := make([]ProcessInfo, len(procs))
+	copy(sorted, procs)
+	sort.SliceStable(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+	if len(sorted) > count {
+		sorted = sorted[:count]
+	}
+	return sorted
 }
 
-// processMediaStreamUpdate processes a single media update from the stream
-func (app *Application) processMediaStreamUpdate(client mqtt.Client, mediaData map[string]interface{}) {
-	// The stream sends {"type":"data","diff":true,"payload":{...}}
-	// Only update fields present in payload
-	payload, ok := mediaData["payload"].(map[string]interface{})
-	if !ok {
-		log.Printf("Media stream: No payload in event, skipping")
+// startProcessMonitor samples the top processes on their own interval
+func (app *Application) startProcessMonitor(client mqtt.Client) {
+	if !app.config.Processes.Enabled {
 		return
 	}
 
-	// Merge payload into currentMediaState
-	for k, v := range payload {
-		switch k {
-		case "title":
-			if s, ok := v.(string); ok {
-				app.currentMediaState.Title = s
-			}
-		case "artist":
-			if s, ok := v.(string); ok {
-				app.currentMediaState.Artist = s
-			}
-		case "album":
-			if s, ok := v.(string); ok {
-				app.currentMediaState.Album = s
-			}
-		case "appName":
-			if s, ok := v.(string); ok {
-				app.currentMediaState.AppName = s
-			}
-		case "bundleIdentifier":
-			if s, ok := v.(string); ok {
-				app.currentMediaState.AppName = s
-			}
-		case "playing":
-			if b, ok := v.(bool); ok {
-				if b {
-					app.currentMediaState.State = "playing"
-				} else {
-					app.currentMediaState.State = "paused"
-				}
-			}
-		case "duration":
-			if f, ok := v.(float64); ok {
-				app.currentMediaState.Duration = int(f)
-			}
-		case "durationMicros":
-			if f, ok := v.(float64); ok {
-				app.currentMediaState.Duration = int(f / 1000000)
-			}
-		case "totalTime":
-			if f, ok := v.(float64); ok {
-				app.currentMediaState.Duration = int(f)
-			}
-		case "totalDuration":
-			if f, ok := v.(float64); ok {
-				app.currentMediaState.Duration = int(f)
-			}
-		case "elapsedTime":
-			if f, ok := v.(float64); ok {
-				app.currentMediaState.Position = int(f)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Process monitor goroutine recovered from panic: %v", r)
 			}
-		case "position":
-			if f, ok := v.(float64); ok {
-				app.currentMediaState.Position = int(f)
+		}()
+
+		// The first sample only records CPU times, usage is reported from the second one
+		if _, err := app.sampleProcesses(); err != nil {
+			log.Printf("Failed to sample processes: %v", err)
+		}
+
+		ticker := time.NewTicker(time.Duration(app.config.Processes.Interval) * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			if client.IsConnected() {
+				app.updateTopProcesses(client)
 			}
-		case "positionMicros":
-			if f, ok := v.(float64); ok {
-				app.currentMediaState.Position = int(f / 1000000)
+		}
+	}()
+}
+
+func (app *Application) updateTopProcesses(client mqtt.Client) {
+	procs, err := app.sampleProcesses()
+	if err != nil {
+		log.Printf("Failed to sample processes: %v", err)
+		return
+	}
+
+	count := app.config.Processes.Count
+	for _, top := range []struct {
+		key  string
+		less func(a, b ProcessInfo) bool
+	}{
+		{"cpu", func(a, b ProcessInfo) bool { return a.CPUPercent > b.CPUPercent }},
+		{"memory", func(a, b ProcessInfo) bool { return a.RSS > b.RSS }},
+	} {
+		prefix := app.getTopicPrefix() + "/status/processes/top_" + top.key
+		list := topProcesses(procs, count, top.less)
+		if len(list) == 0 {
+			continue
+		}
+		client.Publish(prefix, 0, false, list[0].Name)
+		attrJSON, _ := json.Marshal(map[string]interface{}{"processes": list})
+		client.Publish(prefix+"/attr", 0, false, string(attrJSON))
+	}
+}
+
+// appCommands are the commands that can be allowed for a watched application
+var appCommands = map[string]bool{
+	"launch": true, // open -a
+	"quit":   true, // ask the application to quit via AppleScript
+	"kill":   true, // SIGKILL every matching process
+}
+
+// validateApps validates the watched applications configuration
+func (app *Application) validateApps() error {
+	seen := make(map[string]bool)
+	for _, watched := range app.config.Apps {
+		slug := watched.Slug()
+		if slug == "" {
+			return fmt.Errorf("app name %q must contain letters or digits", watched.Name)
+		}
+		if seen[slug] {
+			return fmt.Errorf("duplicate app %q", watched.Name)
+		}
+		seen[slug] = true
+		for _, command := range watched.Commands {
+			if !appCommands[command] {
+				return fmt.Errorf("app %s: unknown command %q", watched.Name, command)
 			}
 		}
 	}
+	return nil
+}
 
-	// If playing is false and no other info, treat as idle
-	if state, ok := payload["playing"]; ok {
-		if b, ok := state.(bool); ok && !b {
-			app.currentMediaState.State = "idle"
+// isAppRunning reports whether a process with the exact name is running
+func isAppRunning(process string) bool {
+	// pgrep exits with 1 when nothing matches
+	return exec.Command("/usr/bin/pgrep", "-x", process).Run() == nil
+}
+
+// runAppCommand launches, quits or force-kills a watched application
+func runAppCommand(watched AppConfig, command string) error {
+	var cmd *exec.Cmd
+	switch command {
+	case "launch":
+		cmd = exec.Command("/usr/bin/open", "-a", watched.Name)
+	case "quit":
+		cmd = exec.Command("/usr/bin/osascript", "-e", "tell application "+appleScriptString(watched.Name)+" to quit")
+	case "kill":
+		cmd = exec.Command("/usr/bin/pkill", "-9", "-x", watched.Process)
+	default:
+		return fmt.Errorf("unknown app command %q", command)
+	}
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %v: %s", command, watched.Name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (app *Application) updateApps(client mqtt.Client) {
+	for _, watched := range app.config.Apps {
+		state := "OFF"
+		if isAppRunning(watched.Process) {
+			state = "ON"
+		}
+		client.Publish(app.getTopicPrefix()+"/status/app/"+watched.Slug()+"/running", 0, false, state)
+	}
+}
+
+// handleAppCommand handles launch, quit and kill commands for watched applications
+func (app *Application) handleAppCommand(client mqtt.Client, topic, payload string) bool {
+	prefix := app.getTopicPrefix() + "/command/app/"
+	if !strings.HasPrefix(topic, prefix) {
+		return false
+	}
+
+	slug := strings.TrimPrefix(topic, prefix)
+	for _, watched := range app.config.Apps {
+		if watched.Slug() != slug {
+			continue
+		}
+		if !watched.Allows(payload) {
+			log.Printf("App command %q is not allowed for %s", payload, watched.Name)
+			return true
+		}
+
+		log.Printf("Running app command %s for %s", payload, watched.Name)
+		if err := runAppCommand(watched, payload); err != nil {
+			log.Printf("Error running app command: %v", err)
+		}
+		// Applications take a moment to start or quit
+		time.AfterFunc(2*time.Second, func() { app.updateApps(client) })
+		return true
+	}
+
+	log.Printf("Unknown app: %s", slug)
+	return true
+}
+
+func getSystemUptime() (*UptimeInfo, error) {
+	uptime := sigar.Uptime{}
+	if err := uptime.Get(); err != nil {
+		return nil, fmt.Errorf("failed to get uptime: %w", err)
+	}
+
+	// Convert to human-readable format
+	totalSeconds := uint64(uptime.Length)
+	days := totalSeconds / 86400
+	hours := (totalSeconds % 86400) / 3600
+	minutes := (totalSeconds % 3600) / 60
+
+	var uptimeHuman string
+	if days > 0 {
+		uptimeHuman = fmt.Sprintf("%d days, %d:%02d", days, hours, minutes)
+	} else {
+		uptimeHuman = fmt.Sprintf("%d:%02d", hours, minutes)
+	}
+
+	return &UptimeInfo{
+		Seconds: totalSeconds,
+		Human:   uptimeHuman,
+	}, nil
+}
+
+func (app *Application) updateBattery(client mqtt.Client) {
+	info, err := getBatteryInfo()
+	if info == nil {
+		log.Printf("Failed to get battery info: %v", err)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get detailed battery info: %v", err)
+	}
+
+	// Publish empty charge if there is no battery
+	percent := ""
+	if info.Percent >= 0 {
+		percent = strconv.Itoa(info.Percent)
+	}
+	token := client.Publish(app.getTopicPrefix()+"/status/battery", 0, false, percent)
+	token.Wait()
+	if info.Percent >= 0 {
+		app.evaluateAlerts(client, "battery", float64(info.Percent))
+	}
+
+	if info.PowerSource != "" {
+		client.Publish(app.getTopicPrefix()+"/status/battery/power_source", 0, false, info.PowerSource)
+	}
+	if info.Percent < 0 {
+		return
+	}
+
+	client.Publish(app.getTopicPrefix()+"/status/battery/state", 0, false, info.State)
+	client.Publish(app.getTopicPrefix()+"/status/battery/charging", 0, false, strconv.FormatBool(info.State == "charging" || info.State == "finishing charge"))
+	if info.TimeRemaining >= 0 {
+		client.Publish(app.getTopicPrefix()+"/status/battery/time_remaining", 0, false, strconv.Itoa(info.TimeRemaining))
+	}
+	if info.CycleCount >= 0 {
+		client.Publish(app.getTopicPrefix()+"/status/battery/cycle_count", 0, false, strconv.Itoa(info.CycleCount))
+	}
+	if info.Condition != "" {
+		client.Publish(app.getTopicPrefix()+"/status/battery/condition", 0, false, info.Condition)
+	}
+	if info.DesignCapacity > 0 {
+		client.Publish(app.getTopicPrefix()+"/status/battery/design_capacity", 0, false, strconv.Itoa(info.DesignCapacity))
+	}
+	if info.MaxCapacity > 0 {
+		client.Publish(app.getTopicPrefix()+"/status/battery/max_capacity", 0, false, strconv.Itoa(info.MaxCapacity))
+	}
+	if health := info.HealthPercent(); health > 0 {
+		client.Publish(app.getTopicPrefix()+"/status/battery/health", 0, false, fmt.Sprintf("%.1f", health))
+	}
+	if info.Temperature > 0 {
+		client.Publish(app.getTopicPrefix()+"/status/battery/temperature", 0, false, fmt.Sprintf("%.1f", info.Temperature))
+	}
+	client.Publish(app.getTopicPrefix()+"/status/battery/adapter_watts", 0, false, strconv.Itoa(info.AdapterWatts))
+}
+
+func (app *Application) updateCaffeinateStatus(client mqtt.Client) {
+	app.keepAwakeMutex.Lock()
+	active := app.keepAwakeCmd != nil
+	attr := map[string]interface{}{}
+	remaining := 0
+	if active {
+		request := app.keepAwakeRequest
+		attr["mode"] = request.Mode
+		attr["reason"] = request.Reason
+		attr["pid"] = app.keepAwakeCmd.Process.Pid
+		if request.Duration > 0 {
+			until := app.keepAwakeStarted.Add(request.Duration)
+			attr["until"] = until.Format(time.RFC3339)
+			remaining = int(math.Max(0, math.Ceil(time.Until(until).Seconds())))
 		}
 	}
+	app.keepAwakeMutex.Unlock()
+
+	attrJSON, _ := json.Marshal(attr)
+	client.Publish(app.getTopicPrefix()+"/status/caffeinate/attr", 0, false, string(attrJSON))
+	client.Publish(app.getTopicPrefix()+"/status/caffeinate/remaining", 0, false, strconv.Itoa(remaining))
+	token := client.Publish(app.getTopicPrefix()+"/status/caffeinate", 0, false, strconv.FormatBool(active))
+	token.Wait()
+}
+
+func (app *Application) updateDiskUsage(client mqtt.Client) {
+	diskUsage, err := getDiskUsage()
+	if err != nil {
+		log.Printf("Failed to get disk usage: %v", err)
+		return
+	}
+
+	// Publish individual metrics
+	client.Publish(app.getTopicPrefix()+"/status/disk/total", 0, false, fmt.Sprintf("%d", diskUsage.Total))
+	client.Publish(app.getTopicPrefix()+"/status/disk/used", 0, false, fmt.Sprintf("%d", diskUsage.Used))
+	client.Publish(app.getTopicPrefix()+"/status/disk/free", 0, false, fmt.Sprintf("%d", diskUsage.Free))
+	client.Publish(app.getTopicPrefix()+"/status/disk/used_percent", 0, false, fmt.Sprintf("%.2f", diskUsage.UsedPercent))
+	client.Publish(app.getTopicPrefix()+"/status/disk/free_percent", 0, false, fmt.Sprintf("%.2f", diskUsage.FreePercent))
+	app.evaluateAlerts(client, "disk", diskUsage.UsedPercent)
+}
+
+// updateVolumeUsage publishes per-volume disk usage and refreshes discovery when volumes come and go
+func (app *Application) updateVolumeUsage(client mqtt.Client) {
+	volumes, err := getVolumeUsages(app.config.Disks)
+	if err != nil {
+		log.Printf("Failed to get volume usage: %v", err)
+		return
+	}
 
-	// Publish state and attributes
-	client.Publish(app.getTopicPrefix()+"/status/now_playing", 0, false, app.currentMediaState.State)
-	attr := map[string]interface{}{
-		"state":    app.currentMediaState.State,
-		"title":    app.currentMediaState.Title,
-		"artist":   app.currentMediaState.Artist,
-		"album":    app.currentMediaState.Album,
-		"app_name": app.currentMediaState.AppName,
-		"duration": app.currentMediaState.Duration,
-		"position": app.currentMediaState.Position,
+	app.diskMutex.Lock()
+	current := make(map[string]bool)
+	for _, volume := range volumes {
+		current[volume.Slug] = true
+	}
+	changed := len(volumes) != len(app.diskVolumes)
+	for _, volume := range app.diskVolumes {
+		if !current[volume.Slug] {
+			log.Printf("Volume %s is no longer mounted", volume.Mount)
+			app.removedDiskSlugs = append(app.removedDiskSlugs, volume.Slug)
+			changed = true
+		}
+	}
+	app.diskVolumes = volumes
+	app.diskMutex.Unlock()
+
+	if changed {
+		app.setDevice(client)
+	}
+
+	for _, volume := range volumes {
+		prefix := app.getTopicPrefix() + "/status/disks/" + volume.Slug
+		client.Publish(prefix+"/total", 0, false, fmt.Sprintf("%d", volume.Total))
+		client.Publish(prefix+"/used", 0, false, fmt.Sprintf("%d", volume.Used))
+		client.Publish(prefix+"/free", 0, false, fmt.Sprintf("%d", volume.Free))
+		client.Publish(prefix+"/used_percent", 0, false, fmt.Sprintf("%.2f", volume.UsedPercent))
+		attrJSON, _ := json.Marshal(map[string]interface{}{
+			"name":    volume.Name,
+			"mount":   volume.Mount,
+			"type":    volume.Type,
+			"device":  volume.Device,
+			"network": volume.Network,
+		})
+		client.Publish(prefix+"/attr", 0, false, string(attrJSON))
+		app.evaluateAlerts(client, "disk:"+volume.Slug, volume.UsedPercent)
 	}
-	attrJSON, _ := json.Marshal(attr)
-	client.Publish(app.getTopicPrefix()+"/status/now_playing_attr", 0, false, string(attrJSON))
-	log.Printf("Media stream update: %s - %s (%s)", app.currentMediaState.Artist, app.currentMediaState.Title, app.currentMediaState.State)
-}
-
-// getUserActivityState gets the current user activity state
-func (app *Application) getUserActivityState() string {
-	app.activityMutex.RLock()
-	defer app.activityMutex.RUnlock()
-	return app.userActivityState
 }
 
-// setUserActivityState sets the user activity state and publishes to MQTT
-func (app *Application) setUserActivityState(client mqtt.Client, state string) {
-	app.activityMutex.Lock()
-	defer app.activityMutex.Unlock()
+// checkVolumeChanges publishes mount and unmount events and refreshes volume sensors when they change
+func (app *Application) checkVolumeChanges(client mqtt.Client) {
+	mounted, err := listMountedVolumes(app.config.Disks)
+	if err != nil {
+		log.Printf("Failed to list mounted volumes: %v", err)
+		return
+	}
 
-	if app.userActivityState != state {
-		app.userActivityState = state
-		if client != nil && client.IsConnected() {
-			client.Publish(app.getTopicPrefix()+"/status/user_activity", 0, false, state)
-			log.Printf("User activity state changed to: %s", state)
-		}
+	current := make(map[string]VolumeUsage)
+	for _, volume := range mounted {
+		current[volume.Mount] = volume
 	}
-}
 
-// resetActivityTimer resets the inactivity timer
-func (app *Application) resetActivityTimer(client mqtt.Client) {
-	app.activityMutex.Lock()
-	defer app.activityMutex.Unlock()
+	app.diskMutex.Lock()
+	previous := app.mountedVolumes
+	app.mountedVolumes = current
+	app.diskMutex.Unlock()
 
-	// Set to active immediately
-	if app.userActivityState != "active" {
-		app.userActivityState = "active"
-		if client != nil && client.IsConnected() {
-			client.Publish(app.getTopicPrefix()+"/status/user_activity", 0, false, "active")
-			log.Printf("User activity detected - state: active")
+	changed := false
+	for mount, volume := range current {
+		if _, ok := previous[mount]; !ok {
+			log.Printf("Volume mounted: %s (%s)", mount, volume.Type)
+			app.publishVolumeEvent(client, "mounted", volume)
+			changed = true
+		}
+	}
+	for mount, volume := range previous {
+		if _, ok := current[mount]; !ok {
+			log.Printf("Volume unmounted: %s", mount)
+			app.publishVolumeEvent(client, "unmounted", volume)
+			changed = true
 		}
 	}
 
-	// Reset or create the timer
-	if app.activityTimer != nil {
-		app.activityTimer.Stop()
+	if changed {
+		app.updateVolumeUsage(client)
 	}
+}
 
-	app.activityTimer = time.AfterFunc(time.Duration(app.config.IdleActivityTime)*time.Second, func() {
-		app.setUserActivityState(client, "inactive")
+// publishVolumeEvent publishes a volume event to PREFIX/event/volume
+func (app *Application) publishVolumeEvent(client mqtt.Client, eventType string, volume VolumeUsage) {
+	app.publishEvent(client, "volume", map[string]interface{}{
+		"event_type": eventType,
+		"volume":     volume.Slug,
+		"name":       volume.Name,
+		"mount":      volume.Mount,
+		"type":       volume.Type,
+		"device":     volume.Device,
+		"network":    volume.Network,
 	})
 }
 
-// getSystemIdleTime gets the system idle time in seconds
-func getSystemIdleTime() (int, error) {
-	cmd := exec.Command("ioreg", "-c", "IOHIDSystem")
-	output, err := cmd.Output()
+func (app *Application) updateCPUUsage(client mqtt.Client) {
+	cpuUsage, err := app.getCPUUsage()
 	if err != nil {
-		return 0, fmt.Errorf("error running ioreg: %w", err)
+		log.Printf("Failed to get CPU usage: %v", err)
+		return
 	}
 
-	// Parse the HIDIdleTime from the output
-	re := regexp.MustCompile(`"HIDIdleTime" = (\d+)`)
-	matches := re.FindStringSubmatch(string(output))
-	if len(matches) < 2 {
-		return 0, fmt.Errorf("HIDIdleTime not found in ioreg output")
+	// Publish CPU metrics
+	client.Publish(app.getTopicPrefix()+"/status/cpu/used_percent", 0, false, fmt.Sprintf("%.2f", cpuUsage.UsedPercent))
+	client.Publish(app.getTopicPrefix()+"/status/cpu/free_percent", 0, false, fmt.Sprintf("%.2f", cpuUsage.FreePercent))
+	client.Publish(app.getTopicPrefix()+"/status/cpu/user_percent", 0, false, fmt.Sprintf("%.2f", cpuUsage.UserPercent))
+	client.Publish(app.getTopicPrefix()+"/status/cpu/system_percent", 0, false, fmt.Sprintf("%.2f", cpuUsage.SystemPercent))
+	client.Publish(app.getTopicPrefix()+"/status/cpu/iowait_percent", 0, false, fmt.Sprintf("%.2f", cpuUsage.IowaitPercent))
+	app.evaluateAlerts(client, "cpu", cpuUsage.UsedPercent)
+
+	load := sigar.LoadAverage{}
+	if err := load.Get(); err != nil {
+		log.Printf("Failed to get load average: %v", err)
+	} else {
+		client.Publish(app.getTopicPrefix()+"/status/cpu/load_1", 0, false, fmt.Sprintf("%.2f", load.One))
+		client.Publish(app.getTopicPrefix()+"/status/cpu/load_5", 0, false, fmt.Sprintf("%.2f", load.Five))
+		client.Publish(app.getTopicPrefix()+"/status/cpu/load_15", 0, false, fmt.Sprintf("%.2f", load.Fifteen))
+		app.evaluateAlerts(client, "load", load.One)
 	}
 
-	idleTimeNanos, err := strconv.ParseInt(matches[1], 10, 64)
-	if err != nil {
-		return 0, fmt.Errorf("error parsing idle time: %w", err)
+	if app.cpuTopology.FrequencyMHz > 0 {
+		client.Publish(app.getTopicPrefix()+"/status/cpu/frequency", 0, false, strconv.Itoa(app.cpuTopology.FrequencyMHz))
 	}
 
-	// Convert nanoseconds to seconds
-	idleTimeSeconds := int(idleTimeNanos / 1000000000)
-	return idleTimeSeconds, nil
+	// Per-core usage is needed for the performance/efficiency split as well
+	if !app.config.CPU.PerCore && app.cpuTopology.EfficiencyCores == 0 {
+		return
+	}
+	cores, err := app.getPerCoreUsage()
+	if err != nil {
+		log.Printf("Failed to get per-core CPU usage: %v", err)
+		return
+	}
+	if app.config.CPU.PerCore {
+		for i, core := range cores {
+			client.Publish(fmt.Sprintf("%s/status/cpu/core/%d/used_percent", app.getTopicPrefix(), i), 0, false, fmt.Sprintf("%.2f", core.UsedPercent))
+		}
+	}
+	efficiency := app.cpuTopology.EfficiencyCores
+	if efficiency > 0 && efficiency < len(cores) {
+		client.Publish(app.getTopicPrefix()+"/status/cpu/efficiency_percent", 0, false, fmt.Sprintf("%.2f", averageCPUUsage(cores[:efficiency])))
+		client.Publish(app.getTopicPrefix()+"/status/cpu/performance_percent", 0, false, fmt.Sprintf("%.2f", averageCPUUsage(cores[efficiency:])))
+	}
 }
 
-// startUserActivityMonitoring starts monitoring user activity using system idle time
-func (app *Application) startUserActivityMonitoring(client mqtt.Client) {
-	// Cancel existing monitoring if running
-	app.activityMutex.Lock()
-	if app.activityCancel != nil {
-		app.activityCancel()
+// averageCPUUsage returns the mean used percentage of cores
+func averageCPUUsage(cores []*CPUUsage) float64 {
+	if len(cores) == 0 {
+		return 0
 	}
-	// Create new context
-	app.activityCtx, app.activityCancel = context.WithCancel(context.Background())
-	ctx := app.activityCtx
-	app.activityMutex.Unlock()
-
-	log.Println("Starting user activity monitoring...")
-
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				log.Printf("Activity monitor goroutine recovered from panic: %v", r)
-			}
-		}()
+	total := 0.0
+	for _, core := range cores {
+		total += core.UsedPercent
+	}
+	return total / float64(len(cores))
+}
 
-		var lastIdleTime int = -1
-		ticker := time.NewTicker(500 * time.Millisecond)
-		defer ticker.Stop()
+func (app *Application) updateMemoryUsage(client mqtt.Client) {
+	memUsage, err := getMemoryUsage()
+	if err != nil {
+		log.Printf("Failed to get memory usage: %v", err)
+		return
+	}
 
-		for {
-			select {
-			case <-ctx.Done():
-				log.Println("Stopping user activity monitoring (context cancelled)")
-				return
-			case <-ticker.C:
-				// Check if client is still connected
-				if client == nil || !client.IsConnected() {
-					continue
-				}
+	// Publish memory metrics
+	client.Publish(app.getTopicPrefix()+"/status/memory/total", 0, false, fmt.Sprintf("%d", memUsage.Total))
+	client.Publish(app.getTopicPrefix()+"/status/memory/used", 0, false, fmt.Sprintf("%d", memUsage.Used))
+	client.Publish(app.getTopicPrefix()+"/status/memory/free", 0, false, fmt.Sprintf("%d", memUsage.Free))
+	client.Publish(app.getTopicPrefix()+"/status/memory/used_percent", 0, false, fmt.Sprintf("%.2f", memUsage.UsedPercent))
+	client.Publish(app.getTopicPrefix()+"/status/memory/free_percent", 0, false, fmt.Sprintf("%.2f", memUsage.FreePercent))
+	app.evaluateAlerts(client, "memory", memUsage.UsedPercent)
 
-				idleTime, err := getSystemIdleTime()
-				if err != nil {
-					log.Printf("Error getting system idle time: %v", err)
-					continue
-				}
+	details, err := getMemoryDetails()
+	if err != nil {
+		log.Printf("Failed to get memory details: %v", err)
+		return
+	}
 
-				// If idle time decreased or is very small, user is active
-				if idleTime < lastIdleTime || idleTime < 2 {
-					app.resetActivityTimer(client)
-				}
+	client.Publish(app.getTopicPrefix()+"/status/memory/swap_total", 0, false, fmt.Sprintf("%d", details.SwapTotal))
+	client.Publish(app.getTopicPrefix()+"/status/memory/swap_used", 0, false, fmt.Sprintf("%d", details.SwapUsed))
+	client.Publish(app.getTopicPrefix()+"/status/memory/swap_used_percent", 0, false, fmt.Sprintf("%.2f", details.SwapUsedPercent))
+	client.Publish(app.getTopicPrefix()+"/status/memory/compressed", 0, false, fmt.Sprintf("%d", details.Compressed))
+	client.Publish(app.getTopicPrefix()+"/status/memory/wired", 0, false, fmt.Sprintf("%d", details.Wired))
+	client.Publish(app.getTopicPrefix()+"/status/memory/active", 0, false, fmt.Sprintf("%d", details.Active))
+	client.Publish(app.getTopicPrefix()+"/status/memory/inactive", 0, false, fmt.Sprintf("%d", details.Inactive))
+	client.Publish(app.getTopicPrefix()+"/status/memory/pressure", 0, false, details.Pressure)
+	app.evaluateAlerts(client, "swap", details.SwapUsedPercent)
+	app.evaluateAlerts(client, "memory_pressure", map[string]float64{"normal": 0, "warn": 1, "critical": 2}[details.Pressure])
+}
 
-				lastIdleTime = idleTime
-				client.Publish(app.getTopicPrefix()+"/status/idle_time_seconds", 0, false, fmt.Sprintf("%d", idleTime))
-			}
-		}
-	}()
+func (app *Application) updateUptime(client mqtt.Client) {
+	uptime, err := getSystemUptime()
+	if err != nil {
+		log.Printf("Failed to get uptime: %v", err)
+		return
+	}
 
-	log.Println("User activity monitoring started successfully")
+	// Publish uptime metrics
+	client.Publish(app.getTopicPrefix()+"/status/uptime/seconds", 0, false, fmt.Sprintf("%d", uptime.Seconds))
+	client.Publish(app.getTopicPrefix()+"/status/uptime/human", 0, false, uptime.Human)
 }
 
-// publishMediaState publishes the current media state to MQTT
-func (app *Application) publishMediaState(client mqtt.Client, state, title, artist, album, appName string, duration, position int) {
-	// Publish individual attributes
-	client.Publish(app.getTopicPrefix()+"/status/media_state", 0, false, state)
-	client.Publish(app.getTopicPrefix()+"/status/media_title", 0, false, title)
-	client.Publish(app.getTopicPrefix()+"/status/media_artist", 0, false, artist)
-	client.Publish(app.getTopicPrefix()+"/status/media_album", 0, false, album)
-	client.Publish(app.getTopicPrefix()+"/status/media_app", 0, false, appName)
-	client.Publish(app.getTopicPrefix()+"/status/media_duration", 0, false, strconv.Itoa(duration))
-	client.Publish(app.getTopicPrefix()+"/status/media_position", 0, false, strconv.Itoa(position))
+func getMediaDevicesState() (bool, bool, error) {
+	isMicOn, err := mediadevices.IsMicrophoneOn()
+	if err != nil {
+		return false, false, fmt.Errorf("failed to get microphone state: %w", err)
+	}
 
-	// Publish combined JSON state for media_player entity
-	mediaState := map[string]interface{}{
-		"state":        state,
-		"title":        title,
-		"artist":       artist,
-		"album":        album,
-		"app_name":     appName,
-		"duration":     duration,
-		"position":     position,
-		"media_title":  title,
-		"media_artist": artist,
-		"media_album":  album,
+	isCameraOn, err := mediadevices.IsCameraOn()
+	if err != nil {
+		return isMicOn, false, fmt.Errorf("failed to get camera state: %w", err)
 	}
 
-	stateJSON, _ := json.Marshal(mediaState)
-	mediaPlayerTopic := app.getTopicPrefix() + "/status/media_player"
-	client.Publish(mediaPlayerTopic, 0, false, string(stateJSON))
-	log.Printf("Published media state to %s: %s", mediaPlayerTopic, string(stateJSON))
+	return isMicOn, isCameraOn, nil
 }
 
-// updateDisplayBrightness updates the MQTT topics with current display brightness values
-func (app *Application) updateDisplayBrightness(client mqtt.Client) {
-	// Skip if no displays are available
-	if len(app.displays) == 0 {
+func (app *Application) updateMediaDevices(client mqtt.Client) {
+	isMicOn, isCameraOn, err := getMediaDevicesState()
+	if err != nil {
+		log.Printf("Failed to get media devices state: %v", err)
+		// Publish "unknown" state on error
+		client.Publish(app.getTopicPrefix()+"/status/microphone", 0, false, "OFF")
+		client.Publish(app.getTopicPrefix()+"/status/camera", 0, false, "OFF")
 		return
 	}
 
-	// Refresh display list to handle dynamic display changes (laptop open/close)
-	currentDisplays := getDisplays()
-	if currentDisplays != nil {
-		app.displays = currentDisplays
+	// Publish media device states
+	micState := "OFF"
+	if isMicOn {
+		micState = "ON"
+	}
+	cameraState := "OFF"
+	if isCameraOn {
+		cameraState = "ON"
 	}
 
-	for _, display := range app.displays {
-		brightness, err := getDisplayBrightness(display.DisplayID)
-		if err != nil {
-			// Only log error once per minute to avoid spam for unavailable displays (e.g., closed laptop)
-			if display.Name == "Built-in Display" || strings.Contains(display.Name, "Built-in") {
-				// Silently skip built-in display when unavailable (laptop closed)
-				continue
-			}
-			log.Printf("Error getting brightness for display %s: %v", display.Name, err)
-			// Check if it's a BetterDisplay CLI error
-			if !isBetterDisplayCLIAvailable() {
-				log.Printf("BetterDisplay CLI is not available for display %s", display.Name)
-			}
+	client.Publish(app.getTopicPrefix()+"/status/microphone", 0, false, micState)
+	client.Publish(app.getTopicPrefix()+"/status/camera", 0, false, cameraState)
+}
+
+// NetworkInfo holds details about the active network connection
+type NetworkInfo struct {
+	Interface     string    `json:"interface"`      // Interface carrying the default route, e.g. en0
+	InterfaceType string    `json:"interface_type"` // Hardware port name, e.g. Wi-Fi, Ethernet
+	IPv4          string    `json:"ipv4"`
+	IPv6          string    `json:"ipv6"`
+	Gateway       string    `json:"gateway"`
+	WiFi          *WiFiInfo `json:"wifi,omitempty"`
+	EthernetUp    bool      `json:"ethernet_up"` // Any wired Ethernet port has an active link
+}
+
+// WiFiInfo holds details about the current Wi-Fi association
+type WiFiInfo struct {
+	Interface string `json:"interface"`
+	SSID      string `json:"ssid"`
+	BSSID     string `json:"bssid"`
+	RSSI      int    `json:"rssi"`    // dBm, 0 if unknown
+	Channel   string `json:"channel"` // e.g. "149 (5GHz, 80MHz)"
+}
+
+// airportPath is the legacy airport utility, removed in macOS 14.4
+const airportPath = "/System/Library/PrivateFrameworks/Apple80211.framework/Versions/Current/Resources/airport"
+
+// parseRouteGetDefault parses the output of `route -n get default` into interface and gateway
+func parseRouteGetDefault(output string) (string, string) {
+	// $ route -n get default
+	//    route to: default
+	// destination: default
+	//     gateway: 192.168.1.1
+	//   interface: en0
+
+	var iface, gateway string
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
 			continue
 		}
+		switch key {
+		case "interface":
+			iface = strings.TrimSpace(value)
+		case "gateway":
+			gateway = strings.TrimSpace(value)
+		}
+	}
+	return iface, gateway
+}
 
-		statusTopic := app.getTopicPrefix() + "/status/display_" + display.DisplayID + "_brightness"
-		client.Publish(statusTopic, 0, true, strconv.Itoa(brightness))
+// parseHardwarePorts parses the output of `networksetup -listallhardwareports` into device -> port name
+func parseHardwarePorts(output string) map[string]string {
+	// Hardware Port: Wi-Fi
+	// Device: en0
+	// Ethernet Address: a4:83:e7:00:00:00
+
+	ports := make(map[string]string)
+	port := ""
+	for _, line := range strings.Split(output, "\n") {
+		if value, ok := strings.CutPrefix(line, "Hardware Port: "); ok {
+			port = strings.TrimSpace(value)
+		} else if value, ok := strings.CutPrefix(line, "Device: "); ok && port != "" {
+			ports[strings.TrimSpace(value)] = port
+			port = ""
+		}
 	}
+	return ports
 }
 
-func (app *Application) messagePubHandler(client mqtt.Client, msg mqtt.Message) {
-	log.Printf("Received message: %s from topic: %s\n", msg.Payload(), msg.Topic())
-	app.listen(client, msg)
+// isEthernetPort reports whether a hardware port name describes a wired Ethernet adapter
+func isEthernetPort(port string) bool {
+	return strings.Contains(port, "Ethernet") || strings.Contains(port, "LAN")
 }
 
-func (app *Application) connectHandler(client mqtt.Client) {
-	log.Println("Connected to MQTT")
+// parseIfconfigStatus reports whether `ifconfig <iface>` output shows an active link
+func parseIfconfigStatus(output string) bool {
+	return regexp.MustCompile(`(?m)^\s*status: active$`).MatchString(output)
+}
 
-	// Set up device configuration (in case this is a reconnection)
-	app.setDevice(client)
+// parseIpconfigSummary parses the SSID and BSSID from `ipconfig getsummary <iface>`
+func parseIpconfigSummary(output string, info *WiFiInfo) {
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), " : ")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "SSID":
+			info.SSID = value
+		case "BSSID":
+			info.BSSID = value
+		}
+	}
+}
 
-	token := client.Publish(app.getTopicPrefix()+"/status/alive", 0, true, "online")
-	token.Wait()
+// parseAirportInfo parses the output of `airport -I`
+func parseAirportInfo(output string, info *WiFiInfo) {
+	//      agrCtlRSSI: -55
+	//           BSSID: 9c:53:22:00:00:00
+	//            SSID: Office
+	//         channel: 149,80
+
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ": ")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "agrCtlRSSI":
+			info.RSSI, _ = strconv.Atoi(value)
+		case "BSSID":
+			info.BSSID = value
+		case "SSID":
+			info.SSID = value
+		case "channel":
+			info.Channel = value
+		}
+	}
+}
 
-	log.Println("Sending 'online' to topic: " + app.getTopicPrefix() + "/status/alive")
-	app.sub(client, app.getTopicPrefix()+"/command/#")
+// parseSystemProfilerAirPort parses the signal and channel of the current network from `system_profiler SPAirPortDataType`
+func parseSystemProfilerAirPort(output string, info *WiFiInfo) {
+	//         Current Network Information:
+	//           Office:
+	//             PHY Mode: 802.11ax
+	//             Channel: 149 (5GHz, 80MHz)
+	//             Signal / Noise: -55 dBm / -95 dBm
 
-	// Start media stream if not already running (for reconnections)
-	if isMediaControlAvailable() {
-		go app.startMediaStream(client)
+	_, current, ok := strings.Cut(output, "Current Network Information:")
+	if !ok {
+		return
+	}
+	if res := regexp.MustCompile(`Channel: (.+)`).FindStringSubmatch(current); len(res) == 2 {
+		info.Channel = strings.TrimSpace(res[1])
+	}
+	if res := regexp.MustCompile(`Signal / Noise: (-?\d+) dBm`).FindStringSubmatch(current); len(res) == 2 {
+		info.RSSI, _ = strconv.Atoi(res[1])
 	}
+}
 
-	// Start user activity monitoring
-	go app.startUserActivityMonitoring(client)
+// getInterfaceAddresses returns the first IPv4 and the first global IPv6 address of iface
+func getInterfaceAddresses(iface string) (string, string, error) {
+	netIface, err := net.InterfaceByName(iface)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get interface %s: %w", iface, err)
+	}
+	addrs, err := netIface.Addrs()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get addresses of %s: %w", iface, err)
+	}
 
-	// Send initial state updates
-	app.updateVolume(client)
-	app.updateMute(client)
-	app.updateCaffeinateStatus(client)
-	app.updateDisplayBrightness(client)
-	app.updateNowPlaying(client)
-	app.setUserActivityState(client, "inactive") // Initial state
+	var ipv4, ipv6 string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ip := ipNet.IP.To4(); ip != nil {
+			if ipv4 == "" {
+				ipv4 = ip.String()
+			}
+		} else if ipv6 == "" && ipNet.IP.IsGlobalUnicast() {
+			ipv6 = ipNet.IP.String()
+		}
+	}
+	return ipv4, ipv6, nil
 }
 
-func (app *Application) connectLostHandler(_ mqtt.Client, err error) {
-	log.Printf("Disconnected from MQTT: %v", err)
+// getWiFiInfo returns details about the Wi-Fi association of iface, or nil if it is not associated
+func (app *Application) getWiFiInfo(iface string) *WiFiInfo {
+	info := &WiFiInfo{Interface: iface}
 
-	// Check if it's a network issue
-	if !app.isNetworkReachable() {
-		log.Println("MQTT broker is not reachable - likely on a different network")
-		log.Println("Will retry connection when network becomes available")
+	if _, err := os.Stat(airportPath); err == nil {
+		if output, err := exec.Command(airportPath, "-I").Output(); err == nil {
+			parseAirportInfo(string(output), info)
+		}
 	} else {
-		log.Println("MQTT client will attempt to reconnect automatically...")
+		if output, err := exec.Command("/usr/sbin/ipconfig", "getsummary", iface).Output(); err == nil {
+			parseIpconfigSummary(string(output), info)
+		}
+		if info.SSID != "" {
+			info.Channel, info.RSSI = app.getWiFiDetails(info.SSID)
+		}
 	}
-}
 
-func (app *Application) getMQTTClient() error {
-	return app.getMQTTClientWithRetry(0)
+	if info.SSID == "" && info.BSSID == "" {
+		return nil
+	}
+	return info
 }
 
-// isNetworkReachable checks if the MQTT broker is reachable before attempting connection
-func (app *Application) isNetworkReachable() bool {
-	// Try to connect to the broker with a short timeout
-	timeout := 5 * time.Second
-	conn, err := net.DialTimeout("tcp", net.JoinHostPort(app.config.IP, app.config.Port), timeout)
-	if err != nil {
-		log.Printf("Network check failed: MQTT broker %s:%s is not reachable (%v)", app.config.IP, app.config.Port, err)
-		return false
+// getWiFiDetails returns the channel and signal of the current network from system_profiler. It takes seconds to
+// run, so the result is reused for WiFiDetailsInterval unless the network changes.
+func (app *Application) getWiFiDetails(ssid string) (string, int) {
+	app.wifiMutex.Lock()
+	defer app.wifiMutex.Unlock()
+
+	if app.wifiDetails.SSID != ssid || time.Since(app.wifiDetailsTime) >= WiFiDetailsInterval {
+		details := WiFiInfo{SSID: ssid}
+		if output, err := exec.Command("/usr/sbin/system_profiler", "SPAirPortDataType").Output(); err == nil {
+			parseSystemProfilerAirPort(string(output), &details)
+		}
+		app.wifiDetails = details
+		app.wifiDetailsTime = time.Now()
 	}
-	conn.Close()
-	return true
+	return app.wifiDetails.Channel, app.wifiDetails.RSSI
 }
 
-func (app *Application) getMQTTClientWithRetry(retryCount int) error {
-	// Prevent infinite recursion
-	if retryCount > MaxRetryAttempts {
-		return fmt.Errorf("failed to connect to MQTT broker after multiple attempts")
-	}
+// getNetworkInfo collects details about the active network connection
+func (app *Application) getNetworkInfo() (*NetworkInfo, error) {
+	info := &NetworkInfo{}
 
-	// Check network reachability first to avoid long timeouts
-	if !app.isNetworkReachable() {
-		log.Printf("MQTT broker is not reachable on current network, will retry later")
-		return fmt.Errorf("MQTT broker not reachable")
+	output, err := exec.Command("/sbin/route", "-n", "get", "default").Output()
+	if err != nil {
+		return nil, fmt.Errorf("error getting default route: %w", err)
 	}
+	info.Interface, info.Gateway = parseRouteGetDefault(string(output))
 
-	opts := mqtt.NewClientOptions()
+	if info.Interface != "" {
+		info.IPv4, info.IPv6, err = getInterfaceAddresses(info.Interface)
+		if err != nil {
+			log.Printf("Failed to get addresses: %v", err)
+		}
+	}
 
-	// Determine protocol and broker URL
-	protocol := "tcp"
-	if app.config.SSL {
-		protocol = "ssl"
+	output, err = exec.Command("/usr/sbin/networksetup", "-listallhardwareports").Output()
+	if err != nil {
+		return info, fmt.Errorf("error listing hardware ports: %w", err)
+	}
+	ports := parseHardwarePorts(string(output))
+	info.InterfaceType = ports[info.Interface]
+
+	for device, port := range ports {
+		switch {
+		case port == "Wi-Fi":
+			info.WiFi = app.getWiFiInfo(device)
+		case isEthernetPort(port) && !info.EthernetUp:
+			if output, err := exec.Command("/sbin/ifconfig", device).Output(); err == nil {
+				info.EthernetUp = parseIfconfigStatus(string(output))
+			}
+		}
 	}
-	brokerURL := fmt.Sprintf("%s://%s:%s", protocol, app.config.IP, app.config.Port)
-	log.Printf("Connecting to MQTT broker: %s", brokerURL)
 
-	opts.AddBroker(brokerURL)
-	if app.config.User != "" {
-		opts.SetUsername(app.config.User)
+	return info, nil
+}
+
+func (app *Application) updateNetworkInfo(client mqtt.Client) {
+	info, err := app.getNetworkInfo()
+	if info == nil {
+		log.Printf("Failed to get network info: %v", err)
+		return
 	}
-	if app.config.Password != "" {
-		opts.SetPassword(app.config.Password)
+	if err != nil {
+		log.Printf("Failed to get detailed network info: %v", err)
 	}
 
-	// Set up handlers with application context
-	opts.OnConnect = app.connectHandler
-	opts.OnConnectionLost = app.connectLostHandler
-	opts.SetDefaultPublishHandler(app.messagePubHandler)
+	prefix := app.getTopicPrefix() + "/status/network"
+	client.Publish(prefix+"/interface", 0, false, info.Interface)
+	client.Publish(prefix+"/interface_type", 0, false, info.InterfaceType)
+	client.Publish(prefix+"/ipv4", 0, false, info.IPv4)
+	client.Publish(prefix+"/ipv6", 0, false, info.IPv6)
+	client.Publish(prefix+"/gateway", 0, false, info.Gateway)
+	client.Publish(prefix+"/ethernet", 0, false, strconv.FormatBool(info.EthernetUp))
 
-	// Set client ID to ensure unique identification with timestamp to avoid conflicts
-	clientID := fmt.Sprintf("%s_mac2mqtt_%d", app.hostname, time.Now().Unix())
-	opts.SetClientID(clientID)
+	wifi := info.WiFi
+	if wifi == nil {
+		wifi = &WiFiInfo{}
+	}
+	client.Publish(prefix+"/wifi/ssid", 0, false, wifi.SSID)
+	client.Publish(prefix+"/wifi/bssid", 0, false, wifi.BSSID)
+	client.Publish(prefix+"/wifi/channel", 0, false, wifi.Channel)
+	if wifi.RSSI != 0 {
+		client.Publish(prefix+"/wifi/rssi", 0, false, strconv.Itoa(wifi.RSSI))
+	}
+}
 
-	// Network-aware connection reliability settings
-	opts.SetClientID(app.hostname + "_mac2mqtt")
-	opts.SetKeepAlive(60 * time.Second)      // Send ping every 60 seconds
-	opts.SetPingTimeout(10 * time.Second)    // Shorter ping timeout for faster network change detection
-	opts.SetConnectTimeout(15 * time.Second) // Shorter connect timeout for network switching
-	opts.SetAutoReconnect(true)              // Enable auto-reconnect
-	opts.SetConnectRetry(true)
-	opts.SetConnectRetryInterval(15 * time.Second) // Wait 15 seconds between retries (good for network switches)
-	opts.SetMaxReconnectInterval(2 * time.Minute)  // Max 2 minutes between reconnect attempts (faster recovery)
-	opts.SetCleanSession(false)                    // Resume session to avoid losing subscriptions
-	opts.SetOrderMatters(false)                    // Allow out-of-order delivery for better performance
-	opts.SetWriteTimeout(10 * time.Second)         // Shorter write timeout for network issues
-	opts.SetResumeSubs(true)                       // Resume subscriptions on reconnect
+// NetIORate holds per-second network throughput of an interface
+type NetIORate struct {
+	BytesIn    float64 `json:"bytes_in"`
+	BytesOut   float64 `json:"bytes_out"`
+	PacketsIn  float64 `json:"packets_in"`
+	PacketsOut float64 `json:"packets_out"`
+}
 
-	// Set will message
-	opts.SetWill(app.getTopicPrefix()+"/status/alive", "offline", 0, true)
+// getNetIOCounters returns the I/O counters of all interfaces matching patterns
+func getNetIOCounters(patterns []string) (map[string]psnet.IOCountersStat, error) {
+	counters, err := psnet.IOCounters(true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get network counters: %w", err)
+	}
 
-	client := mqtt.NewClient(opts)
-	if token := client.Connect(); token.Wait() && token.Error() != nil {
-		// If SSL connection fails, try falling back to non-SSL
-		if app.config.SSL {
-			log.Printf("SSL connection failed: %v. Trying non-SSL connection...", token.Error())
-			app.config.SSL = false
-			return app.getMQTTClientWithRetry(retryCount + 1)
+	result := make(map[string]psnet.IOCountersStat)
+	for _, counter := range counters {
+		if matchPatterns(counter.Name, patterns) {
+			result[counter.Name] = counter
 		}
-		return fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
 	}
-
-	app.client = client
-	return nil
+	return result, nil
 }
 
-func (app *Application) sub(client mqtt.Client, topic string) {
-	token := client.Subscribe(topic, 0, nil)
-	token.Wait()
-	log.Printf("Subscribed to topic: %s\n", topic)
+// counterDelta returns the increase of a counter, treating a reset as no traffic
+func counterDelta(current, last uint64) uint64 {
+	if current < last {
+		return 0
+	}
+	return current - last
 }
 
-func (app *Application) listen(client mqtt.Client, msg mqtt.Message) {
-	topic := msg.Topic()
-	payload := string(msg.Payload())
-
-	// Handle volume commands
-	if app.handleVolumeCommand(client, topic, payload) {
-		return
+func (app *Application) getNetIORates() (map[string]NetIORate, error) {
+	counters, err := getNetIOCounters(app.config.Network.Interfaces)
+	if err != nil {
+		return nil, err
 	}
+	now := time.Now()
 
-	// Handle mute commands
-	if app.handleMuteCommand(client, topic, payload) {
-		return
+	app.netMutex.Lock()
+	defer app.netMutex.Unlock()
+
+	// Calculate the delta since last measurement
+	elapsed := now.Sub(app.lastNetIOTime).Seconds()
+	rates := make(map[string]NetIORate)
+	for name, counter := range counters {
+		last, ok := app.lastNetIO[name]
+		if !ok || elapsed <= 0 {
+			rates[name] = NetIORate{}
+			continue
+		}
+		rates[name] = NetIORate{
+			BytesIn:    float64(counterDelta(counter.BytesRecv, last.BytesRecv)) / elapsed,
+			BytesOut:   float64(counterDelta(counter.BytesSent, last.BytesSent)) / elapsed,
+			PacketsIn:  float64(counterDelta(counter.PacketsRecv, last.PacketsRecv)) / elapsed,
+			PacketsOut: float64(counterDelta(counter.PacketsSent, last.PacketsSent)) / elapsed,
+		}
 	}
 
-	// Handle system commands
-	if app.handleSystemCommand(topic, payload) {
+	// Store current counters for next calculation
+	app.lastNetIO = counters
+	app.lastNetIOTime = now
+
+	return rates, nil
+}
+
+func (app *Application) updateNetIO(client mqtt.Client) {
+	rates, err := app.getNetIORates()
+	if err != nil {
+		log.Printf("Failed to get network throughput: %v", err)
 		return
 	}
 
-	// Handle display brightness commands
-	if app.handleDisplayBrightnessCommand(client, topic, payload) {
-		return
+	for name, rate := range rates {
+		prefix := app.getTopicPrefix() + "/status/network/" + name
+		client.Publish(prefix+"/bytes_in", 0, false, fmt.Sprintf("%.0f", rate.BytesIn))
+		client.Publish(prefix+"/bytes_out", 0, false, fmt.Sprintf("%.0f", rate.BytesOut))
+		client.Publish(prefix+"/packets_in", 0, false, fmt.Sprintf("%.1f", rate.PacketsIn))
+		client.Publish(prefix+"/packets_out", 0, false, fmt.Sprintf("%.1f", rate.PacketsOut))
 	}
+}
 
-	// Handle shortcut commands
-	if app.handleShortcutCommand(topic, payload) {
-		return
+// ProbeResult holds the outcome of one probe run
+type ProbeResult struct {
+	Latency float64 // average round trip or connect time in milliseconds, 0 if nothing answered
+	Loss    float64 // percentage of packets or connections that failed
+}
+
+// parsePingOutput parses the summary of `ping -c N -q host`
+func parsePingOutput(output string) (*ProbeResult, error) {
+	// 4 packets transmitted, 4 packets received, 0.0% packet loss
+	// round-trip min/avg/max/stddev = 3.012/4.288/6.102/1.170 ms
+
+	res := regexp.MustCompile(`([\d.]+)% packet loss`).FindStringSubmatch(output)
+	if len(res) != 2 {
+		return nil, fmt.Errorf("packet loss not found in ping output")
 	}
+	result := &ProbeResult{}
+	result.Loss, _ = strconv.ParseFloat(res[1], 64)
 
-	// Handle keep awake commands
-	if app.handleKeepAwakeCommand(client, topic, payload) {
-		return
+	if res := regexp.MustCompile(`= [\d.]+/([\d.]+)/`).FindStringSubmatch(output); len(res) == 2 {
+		result.Latency, _ = strconv.ParseFloat(res[1], 64)
 	}
+	return result, nil
+}
 
-	// Handle play/pause commands
-	if app.handlePlayPauseCommand(client, topic, payload) {
-		return
+// pingProbe sends count ICMP echo requests to host
+func pingProbe(host string, count int, timeout time.Duration) (*ProbeResult, error) {
+	waitMillis := strconv.Itoa(int(timeout / time.Millisecond))
+	// ping exits non-zero when packets are lost, the summary is still printed
+	output, err := exec.Command("/sbin/ping", "-c", strconv.Itoa(count), "-q", "-W", waitMillis, host).Output()
+	result, parseErr := parsePingOutput(string(output))
+	if parseErr != nil {
+		if err != nil {
+			return nil, fmt.Errorf("ping %s failed: %w", host, err)
+		}
+		return nil, parseErr
 	}
+	return result, nil
 }
 
-// handleVolumeCommand handles volume control commands
-func (app *Application) handleVolumeCommand(client mqtt.Client, topic, payload string) bool {
-	if topic != app.getTopicPrefix()+"/command/volume" {
-		return false
+// tcpProbe opens count TCP connections to host:port
+func tcpProbe(host, port string, count int, timeout time.Duration) *ProbeResult {
+	var total time.Duration
+	succeeded := 0
+	for i := 0; i < count; i++ {
+		elapsed, err := dialTCP(host, port, timeout)
+		if err != nil {
+			continue
+		}
+		total += elapsed
+		succeeded++
 	}
 
-	volume, err := app.validateVolumeInput(payload)
-	if err != nil {
-		log.Printf("Invalid volume value: %v", err)
-		return true
+	result := &ProbeResult{Loss: float64(count-succeeded) / float64(count) * 100}
+	if succeeded > 0 {
+		result.Latency = float64(total.Microseconds()) / float64(succeeded) / 1000
 	}
+	return result
+}
 
-	setVolume(volume)
-	app.updateVolume(client)
-	app.updateMute(client)
-	return true
+// validateProbes validates the probe configuration
+func (app *Application) validateProbes() error {
+	seen := make(map[string]bool)
+	nameRe := regexp.MustCompile(`^[a-z0-9_]+$`)
+	for _, probe := range app.config.Network.Probes {
+		if !nameRe.MatchString(probe.Name) {
+			return fmt.Errorf("probe name %q must only contain lowercase letters, digits and underscores", probe.Name)
+		}
+		if seen[probe.Name] {
+			return fmt.Errorf("duplicate probe name %q", probe.Name)
+		}
+		seen[probe.Name] = true
+		if probe.Target == "" {
+			return fmt.Errorf("probe %s: target is required", probe.Name)
+		}
+		switch probe.Type {
+		case "ping":
+		case "tcp":
+			if probe.Port == "" && probe.Target != "broker" {
+				return fmt.Errorf("probe %s: port is required for tcp probes", probe.Name)
+			}
+		default:
+			return fmt.Errorf("probe %s: unknown type %q", probe.Name, probe.Type)
+		}
+		if probe.Count < 1 || probe.Interval < 1 || probe.Timeout < 1 {
+			return fmt.Errorf("probe %s: count, interval and timeout must be positive", probe.Name)
+		}
+	}
+	return nil
 }
 
-// handleMuteCommand handles mute control commands
-func (app *Application) handleMuteCommand(client mqtt.Client, topic, payload string) bool {
-	if topic != app.getTopicPrefix()+"/command/mute" {
-		return false
+// runProbe resolves the probe target and runs it once
+func (app *Application) runProbe(probe ProbeConfig) (*ProbeResult, error) {
+	host, port := probe.Target, probe.Port
+	switch probe.Target {
+	case "gateway":
+		output, err := exec.Command("/sbin/route", "-n", "get", "default").Output()
+		if err != nil {
+			return nil, fmt.Errorf("error getting default route: %w", err)
+		}
+		if _, host = parseRouteGetDefault(string(output)); host == "" {
+			return nil, fmt.Errorf("no default gateway")
+		}
+	case "broker":
+		host = app.config.IP
+		if port == "" {
+			port = app.config.Port
+		}
 	}
 
-	mute, err := app.validateMuteInput(payload)
-	if err != nil {
-		log.Printf("Invalid mute value: %v", err)
-		return true
+	timeout := time.Duration(probe.Timeout) * time.Second
+	if probe.Type == "tcp" {
+		return tcpProbe(host, port, probe.Count, timeout), nil
 	}
+	return pingProbe(host, probe.Count, timeout)
+}
 
-	setMute(mute)
-	app.updateVolume(client)
-	app.updateMute(client)
-	return true
+// startProbes runs each configured probe on its own interval
+func (app *Application) startProbes(client mqtt.Client) {
+	for _, probe := range app.config.Network.Probes {
+		go func(probe ProbeConfig) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("Probe %s goroutine recovered from panic: %v", probe.Name, r)
+				}
+			}()
+
+			ticker := time.NewTicker(time.Duration(probe.Interval) * time.Second)
+			defer ticker.Stop()
+			for {
+				if client.IsConnected() {
+					app.updateProbe(client, probe)
+				}
+				<-ticker.C
+			}
+		}(probe)
+	}
 }
 
-// handleSystemCommand handles system control commands
-func (app *Application) handleSystemCommand(topic, payload string) bool {
-	if topic != app.getTopicPrefix()+"/command/set" {
-		return false
+func (app *Application) updateProbe(client mqtt.Client, probe ProbeConfig) {
+	prefix := app.getTopicPrefix() + "/status/probe/" + probe.Name
+	result, err := app.runProbe(probe)
+	if err != nil {
+		log.Printf("Probe %s failed: %v", probe.Name, err)
+		client.Publish(prefix+"/loss", 0, false, "100")
+		return
 	}
 
-	switch payload {
-	case "sleep":
-		commandSleep()
-	case "displaysleep":
-		commandDisplaySleep()
-	case "displaywake":
-		commandDisplayWake()
-	case "shutdown":
-		commandShutdown()
-	case "screensaver":
-		commandScreensaver()
-	default:
-		log.Printf("Unknown system command: %s", payload)
+	client.Publish(prefix+"/loss", 0, false, fmt.Sprintf("%.1f", result.Loss))
+	if result.Loss < 100 {
+		client.Publish(prefix+"/latency", 0, false, fmt.Sprintf("%.2f", result.Latency))
 	}
-	return true
 }
 
-// handleDisplayBrightnessCommand handles display brightness commands
-func (app *Application) handleDisplayBrightnessCommand(client mqtt.Client, topic, payload string) bool {
-	// Check if we have any displays available
-	if len(app.displays) == 0 {
-		log.Printf("Received display brightness command but no displays are available")
-		log.Printf("Topic: %s, Payload: %s", topic, payload)
-		log.Println("This usually means BetterDisplay CLI is not installed or not accessible")
-		return true // Return true to indicate we handled the command
-	}
+// validateCustomSensors validates the custom sensor configuration
+func (app *Application) validateCustomSensors() error {
+	seen := make(map[string]bool)
+	nameRe := regexp.MustCompile(`^[a-z0-9_]+$`)
+	for i := range app.config.Sensors {
+		sensor := &app.config.Sensors[i]
+		if !nameRe.MatchString(sensor.Name) {
+			return fmt.Errorf("sensor name %q must only contain lowercase letters, digits and underscores", sensor.Name)
+		}
+		if seen[sensor.Name] {
+			return fmt.Errorf("duplicate sensor name %q", sensor.Name)
+		}
+		seen[sensor.Name] = true
 
-	for _, display := range app.displays {
-		commandTopic := app.getTopicPrefix() + "/command/display_" + display.DisplayID + "_brightness"
-		if topic == commandTopic {
-			brightness, err := app.validateBrightnessInput(payload)
-			if err != nil {
-				log.Printf("Invalid brightness value for display %s: %v", display.Name, err)
-				return true
+		sources := 0
+		for _, set := range []bool{len(sensor.Argv) > 0, sensor.AppleScript != "", sensor.File != ""} {
+			if set {
+				sources++
 			}
-
-			err = setDisplayBrightness(display.DisplayID, brightness)
-			if err != nil {
-				log.Printf("Error setting brightness for display %s: %v", display.Name, err)
-				// Check if it's a BetterDisplay CLI error
-				if !isBetterDisplayCLIAvailable() {
-					log.Println("BetterDisplay CLI is not available. Please install BetterDisplay and enable CLI access.")
-				}
-			} else {
-				// Update the status immediately
-				statusTopic := app.getTopicPrefix() + "/status/display_" + display.DisplayID + "_brightness"
-				client.Publish(statusTopic, 0, true, strconv.Itoa(brightness))
+		}
+		if sources != 1 {
+			return fmt.Errorf("sensor %s: exactly one of argv, applescript and file is required", sensor.Name)
+		}
+		if len(sensor.Argv) > 0 && !filepath.IsAbs(sensor.Argv[0]) {
+			return fmt.Errorf("sensor %s: argv must start with an absolute path", sensor.Name)
+		}
+		if sensor.File != "" && !filepath.IsAbs(expandHome(sensor.File)) {
+			return fmt.Errorf("sensor %s: file must be an absolute path", sensor.Name)
+		}
+		if sensor.Interval < 1 || sensor.Timeout < 1 {
+			return fmt.Errorf("sensor %s: interval and timeout must be positive", sensor.Name)
+		}
+		if sensor.Regex != "" && sensor.JSONPath != "" {
+			return fmt.Errorf("sensor %s: only one of regex and json_path can be set", sensor.Name)
+		}
+		if sensor.Regex != "" {
+			re, err := regexp.Compile(sensor.Regex)
+			if err != nil {
+				return fmt.Errorf("sensor %s: invalid regex: %w", sensor.Name, err)
 			}
-			return true
+			sensor.re = re
+		}
+		switch sensor.Type {
+		case "string", "int", "float":
+		case "bool":
+			if sensor.Unit != "" || sensor.StateClass != "" {
+				return fmt.Errorf("sensor %s: bool sensors cannot have a unit or state_class", sensor.Name)
+			}
+		default:
+			return fmt.Errorf("sensor %s: unknown type %q", sensor.Name, sensor.Type)
 		}
 	}
-	return false
+	return nil
 }
 
-// handleShortcutCommand handles shortcut execution commands
-func (app *Application) handleShortcutCommand(topic, payload string) bool {
-	if topic != app.getTopicPrefix()+"/command/runshortcut" {
-		return false
+// extractJSONPath returns the value at a dot separated path like "containers.0.name"
+func extractJSONPath(data interface{}, path string) (interface{}, error) {
+	for _, key := range strings.Split(path, ".") {
+		switch node := data.(type) {
+		case map[string]interface{}:
+			value, ok := node[key]
+			if !ok {
+				return nil, fmt.Errorf("key %q not found", key)
+			}
+			data = value
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, fmt.Errorf("invalid index %q", key)
+			}
+			data = node[index]
+		default:
+			return nil, fmt.Errorf("cannot look up %q in a %T", key, data)
+		}
 	}
+	return data, nil
+}
 
-	if err := app.validateShortcutInput(payload); err != nil {
-		log.Printf("Invalid shortcut: %v", err)
-		return true
+// extractSensorValue extracts the value from the raw output and formats it for its type
+func extractSensorValue(sensor CustomSensor, output string) (string, error) {
+	value := strings.TrimSpace(output)
+	switch {
+	case sensor.re != nil:
+		match := sensor.re.FindStringSubmatch(output)
+		if match == nil {
+			return "", fmt.Errorf("regex did not match")
+		}
+		// Use the first capture group when there is one
+		value = match[0]
+		if len(match) > 1 {
+			value = match[1]
+		}
+	case sensor.JSONPath != "":
+		var data interface{}
+		if err := json.Unmarshal([]byte(output), &data); err != nil {
+			return "", fmt.Errorf("invalid JSON: %w", err)
+		}
+		extracted, err := extractJSONPath(data, sensor.JSONPath)
+		if err != nil {
+			return "", err
+		}
+		switch extracted := extracted.(type) {
+		case string:
+			value = extracted
+		case map[string]interface{}, []interface{}:
+			return "", fmt.Errorf("json_path %s is not a single value", sensor.JSONPath)
+		case nil:
+			return "", fmt.Errorf("json_path %s is null", sensor.JSONPath)
+		default:
+			value = fmt.Sprint(extracted)
+		}
 	}
+	value = strings.TrimSpace(value)
 
-	commandRunShortcut(payload)
-	return true
+	switch sensor.Type {
+	case "int":
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a number", value)
+		}
+		return strconv.FormatInt(int64(math.Round(number)), 10), nil
+	case "float":
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a number", value)
+		}
+		return strconv.FormatFloat(number, 'f', -1, 64), nil
+	case "bool":
+		switch strings.ToLower(value) {
+		case "true", "yes", "on", "1":
+			return "ON", nil
+		case "false", "no", "off", "0":
+			return "OFF", nil
+		}
+		return "", fmt.Errorf("%q is not a boolean", value)
+	}
+	return value, nil
 }
 
-// handleKeepAwakeCommand handles keep awake commands
-func (app *Application) handleKeepAwakeCommand(client mqtt.Client, topic, payload string) bool {
-	if topic != app.getTopicPrefix()+"/command/keepawake" {
-		return false
+// readCustomSensor runs the sensor command or reads its file
+func readCustomSensor(sensor CustomSensor) (string, error) {
+	if sensor.File != "" {
+		file, err := os.Open(expandHome(sensor.File))
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		output, err := io.ReadAll(io.LimitReader(file, MaxCommandOutput))
+		return string(output), err
 	}
 
-	keepAwake, err := app.validateKeepAwakeInput(payload)
+	args := sensor.Argv
+	if sensor.AppleScript != "" {
+		args = []string{"/usr/bin/osascript", "-e", sensor.AppleScript}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sensor.Timeout)*time.Second)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
 	if err != nil {
-		log.Printf("Invalid keep awake value: %v", err)
-		return true
+		return "", fmt.Errorf("%s failed: %w", filepath.Base(args[0]), err)
 	}
-
-	if keepAwake {
-		commandKeepAwake()
-	} else {
-		commandAllowSleep()
+	if len(output) > MaxCommandOutput {
+		output = output[:MaxCommandOutput]
 	}
-	app.updateCaffeinateStatus(client)
-	return true
+	return string(output), nil
 }
 
-// handlePlayPauseCommand handles play/pause commands
-func (app *Application) handlePlayPauseCommand(client mqtt.Client, topic, payload string) bool {
-	if topic != app.getTopicPrefix()+"/command/playpause" {
-		return false
+func (app *Application) updateCustomSensor(client mqtt.Client, sensor CustomSensor) {
+	output, err := readCustomSensor(sensor)
+	if err != nil {
+		log.Printf("Sensor %s failed: %v", sensor.Name, err)
+		return
 	}
-
-	if payload == "playpause" {
-		commandPlayPause()
-		// Update the now playing sensor after a short delay to reflect the new state
-		time.Sleep(500 * time.Millisecond)
-		app.updateNowPlaying(client)
+	value, err := extractSensorValue(sensor, output)
+	if err != nil {
+		log.Printf("Sensor %s: %v", sensor.Name, err)
+		return
 	}
-	return true
+	client.Publish(app.getTopicPrefix()+"/status/sensor/"+sensor.Name, 0, false, value)
 }
 
-func (app *Application) updateVolume(client mqtt.Client) {
-	token := client.Publish(app.getTopicPrefix()+"/status/volume", 0, false, strconv.Itoa(getCurrentVolume()))
-	token.Wait()
-}
+// startCustomSensors runs each custom sensor on its own interval
+func (app *Application) startCustomSensors(client mqtt.Client) {
+	for _, sensor := range app.config.Sensors {
+		go func(sensor CustomSensor) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("Sensor %s goroutine recovered from panic: %v", sensor.Name, r)
+				}
+			}()
 
-func (app *Application) updateMute(client mqtt.Client) {
-	token := client.Publish(app.getTopicPrefix()+"/status/mute", 0, false, strconv.FormatBool(getMuteStatus()))
-	token.Wait()
+			ticker := time.NewTicker(time.Duration(sensor.Interval) * time.Second)
+			defer ticker.Stop()
+			for {
+				if client.IsConnected() {
+					app.updateCustomSensor(client, sensor)
+				}
+				<-ticker.C
+			}
+		}(sensor)
+	}
 }
 
-func getBatteryChargePercent() string {
+// PublicIPProvider looks up the public address of this machine
+type PublicIPProvider interface {
+	Name() string
+	Lookup(ctx context.Context, ipv6 bool) (string, error)
+}
 
-	output := getCommandOutput("/usr/bin/pmset", "-g", "batt")
+// dnsIPProvider asks a DNS server that answers with the address the query came from
+type dnsIPProvider struct {
+	name    string
+	server4 string // host:port queried over IPv4
+	server6 string // host:port queried over IPv6
+	query   string
+	qtype   dnsmessage.Type // TypeTXT, or TypeA/TypeAAAA depending on the family
+	class   dnsmessage.Class
+}
 
-	// $ /usr/bin/pmset -g batt
-	// Now drawing from 'Battery Power'
-	//  -InternalBattery-0 (id=4653155)        100%; discharging; 20:00 remaining present: true
+func (p *dnsIPProvider) Name() string {
+	return p.name
+}
 
-	r := regexp.MustCompile(`(\d+)%`)
-	res := r.FindStringSubmatch(output)
-	if len(res) == 0 {
-		return ""
+func (p *dnsIPProvider) Lookup(ctx context.Context, ipv6 bool) (string, error) {
+	network, server, qtype := "udp4", p.server4, p.qtype
+	if ipv6 {
+		network, server = "udp6", p.server6
+		if qtype == dnsmessage.TypeA {
+			qtype = dnsmessage.TypeAAAA
+		}
 	}
 
-	return res[1]
-}
-
-// DiskUsage holds disk usage statistics
-type DiskUsage struct {
-	Total       uint64  `json:"total"`        // Total bytes
-	Used        uint64  `json:"used"`         // Used bytes
-	Free        uint64  `json:"free"`         // Free bytes
-	UsedPercent float64 `json:"used_percent"` // Used percentage
-	FreePercent float64 `json:"free_percent"` // Free percentage
-}
+	name, err := dnsmessage.NewName(p.query)
+	if err != nil {
+		return "", fmt.Errorf("invalid query name %s: %w", p.query, err)
+	}
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(time.Now().UnixNano()), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: p.class}},
+	}
+	packet, err := query.Pack()
+	if err != nil {
+		return "", fmt.Errorf("failed to build DNS query: %w", err)
+	}
 
-func getDiskUsage() (*DiskUsage, error) {
-	fs := sigar.FileSystemList{}
-	if err := fs.Get(); err != nil {
-		return nil, fmt.Errorf("failed to get filesystem list: %w", err)
+	d := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to %s: %w", server, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
 	}
 
-	// Find the root filesystem
-	for _, filesystem := range fs.List {
-		if filesystem.DirName == "/" {
-			usage := sigar.FileSystemUsage{}
-			if err := usage.Get(filesystem.DirName); err != nil {
-				return nil, fmt.Errorf("failed to get disk usage: %w", err)
-			}
+	if _, err := conn.Write(packet); err != nil {
+		return "", fmt.Errorf("failed to send DNS query to %s: %w", server, err)
+	}
+	buf := make([]byte, 1232)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("failed to read DNS response from %s: %w", server, err)
+	}
 
-			// Convert from KB to bytes (gosigar returns values in KB)
-			totalBytes := usage.Total * 1024
-			usedBytes := usage.Used * 1024
-			freeBytes := usage.Free * 1024
+	var response dnsmessage.Message
+	if err := response.Unpack(buf[:n]); err != nil {
+		return "", fmt.Errorf("failed to parse DNS response from %s: %w", server, err)
+	}
+	if response.ID != query.ID {
+		return "", fmt.Errorf("DNS response ID mismatch from %s", server)
+	}
 
-			// Calculate percentages
-			usedPercent := float64(0)
-			freePercent := float64(0)
-			if totalBytes > 0 {
-				usedPercent = float64(usedBytes) / float64(totalBytes) * 100
-				freePercent = float64(freeBytes) / float64(totalBytes) * 100
+	for _, answer := range response.Answers {
+		var candidate string
+		switch body := answer.Body.(type) {
+		case *dnsmessage.TXTResource:
+			if len(body.TXT) > 0 {
+				candidate = body.TXT[0]
 			}
-
-			return &DiskUsage{
-				Total:       totalBytes,
-				Used:        usedBytes,
-				Free:        freeBytes,
-				UsedPercent: usedPercent,
-				FreePercent: freePercent,
-			}, nil
+		case *dnsmessage.AResource:
+			candidate = net.IP(body.A[:]).String()
+		case *dnsmessage.AAAAResource:
+			candidate = net.IP(body.AAAA[:]).String()
+		}
+		if ip, err := parsePublicIP(candidate, ipv6); err == nil {
+			return ip, nil
 		}
 	}
-
-	return nil, fmt.Errorf("root filesystem not found")
-}
-
-// CPUUsage holds CPU usage statistics
-type CPUUsage struct {
-	UsedPercent float64 `json:"used_percent"` // CPU used percentage
-	FreePercent float64 `json:"free_percent"` // CPU idle/free percentage
+	return "", fmt.Errorf("no IP address found in DNS response from %s", server)
 }
 
-// MemoryUsage holds memory usage statistics
-type MemoryUsage struct {
-	Total       uint64  `json:"total"`        // Total bytes
-	Used        uint64  `json:"used"`         // Used bytes
-	Free        uint64  `json:"free"`         // Free bytes
-	UsedPercent float64 `json:"used_percent"` // Used percentage
-	FreePercent float64 `json:"free_percent"` // Free percentage
+// httpsIPProvider fetches a plain text address from an ipify-style HTTPS endpoint
+type httpsIPProvider struct {
+	url4 string
+	url6 string
 }
 
-// UptimeInfo holds system uptime information
-type UptimeInfo struct {
-	Seconds uint64 `json:"seconds"` // Uptime in seconds
-	Human   string `json:"human"`   // Human-readable format
+func (p *httpsIPProvider) Name() string {
+	return "https"
 }
 
-func (app *Application) getCPUUsage() (*CPUUsage, error) {
-	cpu := sigar.Cpu{}
-	if err := cpu.Get(); err != nil {
-		return nil, fmt.Errorf("failed to get CPU stats: %w", err)
+func (p *httpsIPProvider) Lookup(ctx context.Context, ipv6 bool) (string, error) {
+	network, url := "tcp4", p.url4
+	if ipv6 {
+		network, url = "tcp6", p.url6
 	}
 
-	app.cpuMutex.Lock()
-	defer app.cpuMutex.Unlock()
-
-	// Calculate the delta since last measurement
-	userDelta := cpu.User - app.lastCPU.User
-	sysDelta := cpu.Sys - app.lastCPU.Sys
-	idleDelta := cpu.Idle - app.lastCPU.Idle
-	waitDelta := cpu.Wait - app.lastCPU.Wait
-	niceDelta := cpu.Nice - app.lastCPU.Nice
-	irqDelta := cpu.Irq - app.lastCPU.Irq
-	softIrqDelta := cpu.SoftIrq - app.lastCPU.SoftIrq
-	stolenDelta := cpu.Stolen - app.lastCPU.Stolen
-
-	// Calculate total time delta
-	totalDelta := userDelta + sysDelta + idleDelta + waitDelta + niceDelta + irqDelta + softIrqDelta + stolenDelta
-
-	// Store current CPU stats for next calculation
-	app.lastCPU = cpu
+	// Force the address family so the endpoint sees the address we ask for
+	d := net.Dialer{Timeout: 5 * time.Second}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
 
-	// If this is the first measurement or total is zero, return 0% usage
-	if totalDelta == 0 {
-		return &CPUUsage{
-			UsedPercent: 0,
-			FreePercent: 100,
-		}, nil
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %s: %w", url, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status from %s: %s", url, resp.Status)
 	}
 
-	// Calculate idle and used percentages
-	idlePercent := float64(idleDelta) / float64(totalDelta) * 100
-	usedPercent := 100 - idlePercent
-
-	return &CPUUsage{
-		UsedPercent: usedPercent,
-		FreePercent: idlePercent,
-	}, nil
-}
-
-func getMemoryUsage() (*MemoryUsage, error) {
-	vmStat, err := mem.VirtualMemory()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
 	if err != nil {
-		log.Fatalf("Error getting virtual memory stats: %v", err)
+		return "", fmt.Errorf("failed to read response from %s: %w", url, err)
 	}
+	return parsePublicIP(strings.TrimSpace(string(body)), ipv6)
+}
 
-	total := vmStat.Total
-	used := vmStat.Used
-	free := vmStat.Free
+// stunIPProvider sends a STUN binding request (RFC 5389) and reads the mapped address
+type stunIPProvider struct {
+	server string
+}
 
-	return &MemoryUsage{
-		Total:       total,
-		Used:        used,
-		Free:        free,
-		UsedPercent: vmStat.UsedPercent,
-		FreePercent: 100 - vmStat.UsedPercent,
-	}, nil
+// stunMagicCookie is the fixed value in every RFC 5389 STUN header
+const stunMagicCookie = 0x2112A442
+
+func (p *stunIPProvider) Name() string {
+	return "stun"
 }
 
-func getSystemUptime() (*UptimeInfo, error) {
-	uptime := sigar.Uptime{}
-	if err := uptime.Get(); err != nil {
-		return nil, fmt.Errorf("failed to get uptime: %w", err)
+func (p *stunIPProvider) Lookup(ctx context.Context, ipv6 bool) (string, error) {
+	network := "udp4"
+	if ipv6 {
+		network = "udp6"
 	}
 
-	// Convert to human-readable format
-	totalSeconds := uint64(uptime.Length)
-	days := totalSeconds / 86400
-	hours := (totalSeconds % 86400) / 3600
-	minutes := (totalSeconds % 3600) / 60
+	request := make([]byte, 20)
+	binary.BigEndian.PutUint16(request[0:2], 0x0001) // Binding Request
+	binary.BigEndian.PutUint32(request[4:8], stunMagicCookie)
+	if _, err := rand.Read(request[8:20]); err != nil {
+		return "", fmt.Errorf("failed to generate STUN transaction ID: %w", err)
+	}
 
-	var uptimeHuman string
-	if days > 0 {
-		uptimeHuman = fmt.Sprintf("%d days, %d:%02d", days, hours, minutes)
-	} else {
-		uptimeHuman = fmt.Sprintf("%d:%02d", hours, minutes)
+	d := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := d.DialContext(ctx, network, p.server)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to %s: %w", p.server, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
 	}
 
-	return &UptimeInfo{
-		Seconds: totalSeconds,
-		Human:   uptimeHuman,
-	}, nil
-}
+	if _, err := conn.Write(request); err != nil {
+		return "", fmt.Errorf("failed to send STUN request to %s: %w", p.server, err)
+	}
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("failed to read STUN response from %s: %w", p.server, err)
+	}
 
-func (app *Application) updateBattery(client mqtt.Client) {
-	token := client.Publish(app.getTopicPrefix()+"/status/battery", 0, false, getBatteryChargePercent())
-	token.Wait()
+	ip, err := parseSTUNResponse(buf[:n], request[8:20])
+	if err != nil {
+		return "", fmt.Errorf("invalid STUN response from %s: %w", p.server, err)
+	}
+	return parsePublicIP(ip, ipv6)
 }
 
-func (app *Application) updateCaffeinateStatus(client mqtt.Client) {
-	token := client.Publish(app.getTopicPrefix()+"/status/caffeinate", 0, false, strconv.FormatBool(getCaffeinateStatus()))
-	token.Wait()
-}
+// parseSTUNResponse returns the address from the (XOR-)MAPPED-ADDRESS attribute of a binding response
+func parseSTUNResponse(response, transactionID []byte) (string, error) {
+	if len(response) < 20 || binary.BigEndian.Uint16(response[0:2]) != 0x0101 {
+		return "", fmt.Errorf("not a binding success response")
+	}
+	if !bytes.Equal(response[8:20], transactionID) {
+		return "", fmt.Errorf("transaction ID mismatch")
+	}
 
-func (app *Application) updateDiskUsage(client mqtt.Client) {
-	diskUsage, err := getDiskUsage()
-	if err != nil {
-		log.Printf("Failed to get disk usage: %v", err)
-		return
+	attrs := response[20:]
+	if length := int(binary.BigEndian.Uint16(response[2:4])); length < len(attrs) {
+		attrs = attrs[:length]
 	}
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:2])
+		attrLen := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if len(attrs) < 4+attrLen {
+			break
+		}
+		value := attrs[4 : 4+attrLen]
+
+		if (attrType == 0x0020 || attrType == 0x0001) && len(value) >= 8 {
+			var ip net.IP
+			switch value[1] {
+			case 0x01:
+				ip = net.IP(append([]byte(nil), value[4:8]...))
+			case 0x02:
+				if len(value) < 20 {
+					return "", fmt.Errorf("short IPv6 mapped address")
+				}
+				ip = net.IP(append([]byte(nil), value[4:20]...))
+			}
+			if ip != nil {
+				// XOR-MAPPED-ADDRESS is obfuscated with the magic cookie followed by the transaction ID
+				if attrType == 0x0020 {
+					key := append(binary.BigEndian.AppendUint32(nil, stunMagicCookie), transactionID...)
+					for i := range ip {
+						ip[i] ^= key[i]
+					}
+				}
+				return ip.String(), nil
+			}
+		}
 
-	// Publish individual metrics
-	client.Publish(app.getTopicPrefix()+"/status/disk/total", 0, false, fmt.Sprintf("%d", diskUsage.Total))
-	client.Publish(app.getTopicPrefix()+"/status/disk/used", 0, false, fmt.Sprintf("%d", diskUsage.Used))
-	client.Publish(app.getTopicPrefix()+"/status/disk/free", 0, false, fmt.Sprintf("%d", diskUsage.Free))
-	client.Publish(app.getTopicPrefix()+"/status/disk/used_percent", 0, false, fmt.Sprintf("%.2f", diskUsage.UsedPercent))
-	client.Publish(app.getTopicPrefix()+"/status/disk/free_percent", 0, false, fmt.Sprintf("%.2f", diskUsage.FreePercent))
+		// Attributes are padded to a multiple of 4 bytes
+		attrs = attrs[4+(attrLen+3)&^3:]
+	}
+	return "", fmt.Errorf("no mapped address attribute")
 }
 
-func (app *Application) updateCPUUsage(client mqtt.Client) {
-	cpuUsage, err := app.getCPUUsage()
-	if err != nil {
-		log.Printf("Failed to get CPU usage: %v", err)
-		return
+// parsePublicIP validates that s is an address of the requested family
+func parsePublicIP(s string, ipv6 bool) (string, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return "", fmt.Errorf("invalid IP address %q", s)
 	}
-
-	// Publish CPU metrics
-	client.Publish(app.getTopicPrefix()+"/status/cpu/used_percent", 0, false, fmt.Sprintf("%.2f", cpuUsage.UsedPercent))
-	client.Publish(app.getTopicPrefix()+"/status/cpu/free_percent", 0, false, fmt.Sprintf("%.2f", cpuUsage.FreePercent))
+	if (ip.To4() == nil) != ipv6 {
+		return "", fmt.Errorf("%s is not an address of the requested family", s)
+	}
+	return ip.String(), nil
 }
 
-func (app *Application) updateMemoryUsage(client mqtt.Client) {
-	memUsage, err := getMemoryUsage()
-	if err != nil {
-		log.Printf("Failed to get memory usage: %v", err)
-		return
+// newPublicIPProvider creates a provider from its configuration
+func newPublicIPProvider(cfg PublicIPProviderConfig) (PublicIPProvider, error) {
+	var provider PublicIPProvider
+	switch cfg.Type {
+	case "google":
+		provider = &dnsIPProvider{name: "google", server4: "ns1.google.com:53", server6: "ns1.google.com:53",
+			query: "o-o.myaddr.l.google.com.", qtype: dnsmessage.TypeTXT, class: dnsmessage.ClassINET}
+	case "cloudflare":
+		provider = &dnsIPProvider{name: "cloudflare", server4: "1.1.1.1:53", server6: "[2606:4700:4700::1111]:53",
+			query: "whoami.cloudflare.", qtype: dnsmessage.TypeTXT, class: dnsmessage.ClassCHAOS}
+	case "opendns":
+		provider = &dnsIPProvider{name: "opendns", server4: "resolver1.opendns.com:53", server6: "resolver1.opendns.com:53",
+			query: "myip.opendns.com.", qtype: dnsmessage.TypeA, class: dnsmessage.ClassINET}
+	case "https":
+		p := &httpsIPProvider{url4: "https://api.ipify.org", url6: "https://api6.ipify.org"}
+		if cfg.Server != "" {
+			p.url4 = cfg.Server
+		}
+		if cfg.ServerIPv6 != "" {
+			p.url6 = cfg.ServerIPv6
+		}
+		return p, nil
+	case "stun":
+		p := &stunIPProvider{server: "stun.l.google.com:19302"}
+		if cfg.Server != "" {
+			p.server = cfg.Server
+		}
+		return p, nil
+	default:
+		return nil, fmt.Errorf("unknown public IP provider %q", cfg.Type)
 	}
 
-	// Publish memory metrics
-	client.Publish(app.getTopicPrefix()+"/status/memory/total", 0, false, fmt.Sprintf("%d", memUsage.Total))
-	client.Publish(app.getTopicPrefix()+"/status/memory/used", 0, false, fmt.Sprintf("%d", memUsage.Used))
-	client.Publish(app.getTopicPrefix()+"/status/memory/free", 0, false, fmt.Sprintf("%d", memUsage.Free))
-	client.Publish(app.getTopicPrefix()+"/status/memory/used_percent", 0, false, fmt.Sprintf("%.2f", memUsage.UsedPercent))
-	client.Publish(app.getTopicPrefix()+"/status/memory/free_percent", 0, false, fmt.Sprintf("%.2f", memUsage.FreePercent))
-}
-
-func (app *Application) updateUptime(client mqtt.Client) {
-	uptime, err := getSystemUptime()
-	if err != nil {
-		log.Printf("Failed to get uptime: %v", err)
-		return
+	// DNS servers can be overridden, e.g. to test against a local stub resolver
+	p := provider.(*dnsIPProvider)
+	if cfg.Server != "" {
+		p.server4 = cfg.Server
 	}
+	if cfg.ServerIPv6 != "" {
+		p.server6 = cfg.ServerIPv6
+	}
+	return p, nil
+}
 
-	// Publish uptime metrics
-	client.Publish(app.getTopicPrefix()+"/status/uptime/seconds", 0, false, fmt.Sprintf("%d", uptime.Seconds))
-	client.Publish(app.getTopicPrefix()+"/status/uptime/human", 0, false, uptime.Human)
+// lookupPublicIP asks each provider in order and returns the first answer
+func lookupPublicIP(providers []PublicIPProvider, ipv6 bool) (string, string, error) {
+	var errs []string
+	for _, provider := range providers {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ip, err := provider.Lookup(ctx, ipv6)
+		cancel()
+		if err == nil {
+			return ip, provider.Name(), nil
+		}
+		errs = append(errs, provider.Name()+": "+err.Error())
+	}
+	return "", "", fmt.Errorf("all public IP providers failed: %s", strings.Join(errs, "; "))
 }
 
-func getMediaDevicesState() (bool, bool, error) {
-	isMicOn, err := mediadevices.IsMicrophoneOn()
-	if err != nil {
-		return false, false, fmt.Errorf("failed to get microphone state: %w", err)
+// publicIPCache remembers the last public address of one family
+type publicIPCache struct {
+	ip      string
+	fetched time.Time
+}
+
+func (app *Application) updatePublicIP(client mqtt.Client) {
+	app.updatePublicIPFamily(client, false, &app.publicIPv4)
+	if app.config.PublicIP.IPv6 {
+		app.updatePublicIPFamily(client, true, &app.publicIPv6)
 	}
+}
 
-	isCameraOn, err := mediadevices.IsCameraOn()
-	if err != nil {
-		return isMicOn, false, fmt.Errorf("failed to get camera state: %w", err)
+// updatePublicIPFamily publishes the public address of one family, looking it up once the cache expires
+func (app *Application) updatePublicIPFamily(client mqtt.Client, ipv6 bool, cache *publicIPCache) {
+	topic, family := app.getTopicPrefix()+"/status/public_ip", "ipv4"
+	if ipv6 {
+		topic, family = app.getTopicPrefix()+"/status/public_ipv6", "ipv6"
 	}
 
-	return isMicOn, isCameraOn, nil
-}
+	ttl := time.Duration(app.config.PublicIP.CacheTTL) * time.Second
+	if cache.ip != "" && time.Since(cache.fetched) < ttl {
+		client.Publish(topic, 0, false, cache.ip)
+		return
+	}
 
-func (app *Application) updateMediaDevices(client mqtt.Client) {
-	isMicOn, isCameraOn, err := getMediaDevicesState()
+	publicIP, provider, err := lookupPublicIP(app.publicIPProviders, ipv6)
 	if err != nil {
-		log.Printf("Failed to get media devices state: %v", err)
-		// Publish "unknown" state on error
-		client.Publish(app.getTopicPrefix()+"/status/microphone", 0, false, "OFF")
-		client.Publish(app.getTopicPrefix()+"/status/camera", 0, false, "OFF")
+		log.Printf("Failed to get public %s: %v", family, err)
+		// Keep reporting the last known address while offline instead of flapping to unavailable
+		if cache.ip != "" {
+			client.Publish(topic, 0, false, cache.ip)
+		}
 		return
 	}
 
-	// Publish media device states
-	micState := "OFF"
-	if isMicOn {
-		micState = "ON"
-	}
-	cameraState := "OFF"
-	if isCameraOn {
-		cameraState = "ON"
+	if cache.ip != "" && cache.ip != publicIP {
+		log.Printf("Public %s changed from %s to %s", family, cache.ip, publicIP)
+		app.publishEvent(client, "public_ip", map[string]interface{}{
+			"event_type": "changed",
+			"family":     family,
+			"old":        cache.ip,
+			"new":        publicIP,
+			"provider":   provider,
+		})
 	}
+	cache.ip = publicIP
+	cache.fetched = time.Now()
 
-	client.Publish(app.getTopicPrefix()+"/status/microphone", 0, false, micState)
-	client.Publish(app.getTopicPrefix()+"/status/camera", 0, false, cameraState)
+	// Publish public IP
+	client.Publish(topic, 0, false, publicIP)
 }
 
-func getPublicIP() (string, error) {
-	// Use DNS over HTTPS to query Cloudflare's whoami service
-	resolver := &net.Resolver{
-		PreferGo: true,
-		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
-			d := net.Dialer{
-				Timeout: 5 * time.Second,
-			}
-			return d.DialContext(ctx, "udp", "ns1.google.com:53")
+// alertSensors lists the sensor names alert rules can refer to
+var alertSensors = map[string]bool{
+	"battery": true, // battery charge percent
+	"disk":    true, // root disk used percent
+	"cpu":     true, // CPU used percent
+	"memory":  true, // memory used percent
+	"load":    true, // 1-minute load average
+	"swap":    true, // swap used percent
+	// CPU die temperature in °C
+	"cpu_temperature": true,
+	// memory pressure as 0 (normal), 1 (warn) or 2 (critical)
+	"memory_pressure": true,
+	// "disk:<slug>" refers to the used percent of a volume from the disks config
+}
 
-		},
+// validateAlertRules validates the alert rules from the configuration
+func (app *Application) validateAlertRules() error {
+	seen := make(map[string]bool)
+	nameRe := regexp.MustCompile(`^[a-z0-9_]+$`)
+	for _, rule := range app.config.Alerts {
+		if !nameRe.MatchString(rule.Name) {
+			return fmt.Errorf("alert name %q must only contain lowercase letters, digits and underscores", rule.Name)
+		}
+		if seen[rule.Name] {
+			return fmt.Errorf("duplicate alert name %q", rule.Name)
+		}
+		// alert_event is the Home Assistant key of the alert event entity
+		if rule.Name == "event" {
+			return fmt.Errorf("alert name %q is reserved", rule.Name)
+		}
+		seen[rule.Name] = true
+		if !alertSensors[rule.Sensor] && !strings.HasPrefix(rule.Sensor, "disk:") {
+			return fmt.Errorf("alert %s: unknown sensor %q", rule.Name, rule.Sensor)
+		}
+		if (rule.Above == nil) == (rule.Below == nil) {
+			return fmt.Errorf("alert %s: exactly one of above or below is required", rule.Name)
+		}
+		if rule.Hysteresis < 0 {
+			return fmt.Errorf("alert %s: hysteresis cannot be negative", rule.Name)
+		}
 	}
+	return nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Query TXT record from Cloudflare's whoami service
-	txtRecords, err := resolver.LookupTXT(ctx, "o-o.myaddr.l.google.com")
-	if err != nil {
-		return "", fmt.Errorf("failed to lookup public IP via DNS: %w", err)
+// alertProblem returns the new problem state of a rule for value given its current state
+func (rule *AlertRule) alertProblem(value float64, problem bool) bool {
+	if rule.Above != nil {
+		if problem {
+			return value > *rule.Above-rule.Hysteresis
+		}
+		return value > *rule.Above
 	}
-
-	if len(txtRecords) > 0 {
-		return txtRecords[0], nil
+	if problem {
+		return value < *rule.Below+rule.Hysteresis
 	}
-
-	return "", fmt.Errorf("no IP address found in DNS response")
+	return value < *rule.Below
 }
 
-func (app *Application) updatePublicIP(client mqtt.Client) {
-	publicIP, err := getPublicIP()
-	if err != nil {
-		log.Printf("Failed to get public IP: %v", err)
-		// Publish empty string on error
-		client.Publish(app.getTopicPrefix()+"/status/public_ip", 0, false, "unavailable")
-		return
+// evaluateAlerts checks all alert rules for sensor against value and publishes state changes
+func (app *Application) evaluateAlerts(client mqtt.Client, sensor string, value float64) {
+	app.alertMutex.Lock()
+	defer app.alertMutex.Unlock()
+
+	for i := range app.config.Alerts {
+		rule := &app.config.Alerts[i]
+		if rule.Sensor != sensor {
+			continue
+		}
+
+		problem, known := app.alertStates[rule.Name]
+		newProblem := rule.alertProblem(value, problem)
+		if known && newProblem == problem {
+			continue
+		}
+		app.alertStates[rule.Name] = newProblem
+
+		state := "OFF"
+		if newProblem {
+			state = "ON"
+		}
+		client.Publish(app.getTopicPrefix()+"/status/alert/"+rule.Name, 0, true, state)
+
+		// The first evaluation only establishes the state unless it is already a problem
+		if !known && !newProblem {
+			continue
+		}
+
+		eventType := "resolved"
+		threshold := rule.Below
+		if newProblem {
+			eventType = "problem"
+		}
+		if rule.Above != nil {
+			threshold = rule.Above
+		}
+		log.Printf("Alert %s %s: %s is %.2f (threshold %.2f)", rule.Name, eventType, sensor, value, *threshold)
+		app.publishEvent(client, "alert", map[string]interface{}{
+			"event_type": eventType,
+			"alert":      rule.Name,
+			"sensor":     sensor,
+			"value":      value,
+			"threshold":  *threshold,
+		})
 	}
+}
 
-	// Publish public IP
-	client.Publish(app.getTopicPrefix()+"/status/public_ip", 0, false, publicIP)
+// publishEvent publishes a one-shot, non-retained event to PREFIX/event/<name>
+func (app *Application) publishEvent(client mqtt.Client, name string, event map[string]interface{}) {
+	event["timestamp"] = time.Now().Format(time.RFC3339)
+	eventJSON, _ := json.Marshal(event)
+	client.Publish(app.getTopicPrefix()+"/event/"+name, 0, false, string(eventJSON))
 }
 
 func (app *Application) setDevice(client mqtt.Client) {
 
 	keepawake := map[string]interface{}{
-		"p":             "switch",
-		"name":          "Keep Awake",
-		"unique_id":     app.hostname + "_keepwake",
-		"command_topic": app.getTopicPrefix() + "/command/keepawake",
-		"payload_on":    "true",
-		"payload_off":   "false",
-		"state_topic":   app.getTopicPrefix() + "/status/caffeinate",
-		"icon":          "mdi:coffee",
+		"p":                     "switch",
+		"name":                  "Keep Awake",
+		"unique_id":             app.hostname + "_keepwake",
+		"command_topic":         app.getTopicPrefix() + "/command/keepawake",
+		"payload_on":            "true",
+		"payload_off":           "false",
+		"state_topic":           app.getTopicPrefix() + "/status/caffeinate",
+		"json_attributes_topic": app.getTopicPrefix() + "/status/caffeinate/attr",
+		"icon":                  "mdi:coffee",
+	}
+
+	keepAwakeRemaining := map[string]interface{}{
+		"p":                   "sensor",
+		"name":                "Keep Awake Remaining",
+		"unique_id":           app.hostname + "_keepawake_remaining",
+		"state_topic":         app.getTopicPrefix() + "/status/caffeinate/remaining",
+		"unit_of_measurement": "s",
+		"device_class":        "duration",
+		"icon":                "mdi:timer-sand",
 	}
 
 	displaywake := map[string]interface{}{
@@ -1851,6 +6402,63 @@ func (app *Application) setDevice(client mqtt.Client) {
 		"enabled_by_default": false,
 		"icon":               "mdi:power",
 	}
+
+	restart := map[string]interface{}{
+		"p":                  "button",
+		"name":               "Restart",
+		"unique_id":          app.hostname + "_restart",
+		"command_topic":      app.getTopicPrefix() + "/command/set",
+		"payload_press":      "restart",
+		"enabled_by_default": false,
+		"icon":               "mdi:restart",
+	}
+
+	logout := map[string]interface{}{
+		"p":                  "button",
+		"name":               "Log Out",
+		"unique_id":          app.hostname + "_logout",
+		"command_topic":      app.getTopicPrefix() + "/command/set",
+		"payload_press":      "logout",
+		"enabled_by_default": false,
+		"icon":               "mdi:logout",
+	}
+
+	systemResult := map[string]interface{}{
+		"p":                     "sensor",
+		"name":                  "System Command Result",
+		"unique_id":             app.hostname + "_system_result",
+		"state_topic":           app.getTopicPrefix() + "/status/system/result",
+		"value_template":        "{{ value_json.status }}",
+		"json_attributes_topic": app.getTopicPrefix() + "/status/system/result",
+		"icon":                  "mdi:shield-check-outline",
+	}
+
+	scheduledAction := map[string]interface{}{
+		"p":                     "sensor",
+		"name":                  "Scheduled Action",
+		"unique_id":             app.hostname + "_scheduled_action",
+		"state_topic":           app.getTopicPrefix() + "/status/scheduled_action",
+		"json_attributes_topic": app.getTopicPrefix() + "/status/scheduled_action/attr",
+		"icon":                  "mdi:timer-cog-outline",
+	}
+
+	scheduledActionAt := map[string]interface{}{
+		"p":            "sensor",
+		"name":         "Scheduled Action Time",
+		"unique_id":    app.hostname + "_scheduled_action_at",
+		"state_topic":  app.getTopicPrefix() + "/status/scheduled_action/at",
+		"device_class": "timestamp",
+		"icon":         "mdi:clock-outline",
+	}
+
+	cancelScheduledAction := map[string]interface{}{
+		"p":             "button",
+		"name":          "Cancel Scheduled Action",
+		"unique_id":     app.hostname + "_cancel_scheduled_action",
+		"command_topic": app.getTopicPrefix() + "/command/set",
+		"payload_press": "cancel",
+		"icon":          "mdi:timer-off-outline",
+	}
 	mute := map[string]interface{}{
 		"p":             "switch",
 		"name":          "Mute",
@@ -1875,6 +6483,47 @@ func (app *Application) setDevice(client mqtt.Client) {
 		"icon":          "mdi:volume-high",
 	}
 
+	volumeButtons := map[string]map[string]interface{}{}
+	for _, button := range []struct{ key, name, topic, payload, icon string }{
+		{"volume_up", "Volume Up", "up", "", "mdi:volume-plus"},
+		{"volume_down", "Volume Down", "down", "", "mdi:volume-minus"},
+		{"volume_duck", "Duck Volume", "duck", "", "mdi:volume-low"},
+		{"volume_restore", "Restore Volume", "duck", "restore", "mdi:volume-high"},
+	} {
+		volumeButtons[button.key] = map[string]interface{}{
+			"p":             "button",
+			"name":          button.name,
+			"unique_id":     app.hostname + "_" + button.key,
+			"command_topic": app.getTopicPrefix() + "/command/volume/" + button.topic,
+			"payload_press": button.payload,
+			"icon":          button.icon,
+		}
+	}
+
+	inputVolume := map[string]interface{}{
+		"p":             "number",
+		"name":          "Microphone Volume",
+		"unique_id":     app.hostname + "_audio_input_volume",
+		"command_topic": app.getTopicPrefix() + "/command/audio/input_volume",
+		"state_topic":   app.getTopicPrefix() + "/status/audio/input_volume",
+		"min_value":     MinVolume,
+		"max_value":     MaxVolume,
+		"step":          1,
+		"mode":          "slider",
+		"icon":          "mdi:microphone",
+	}
+
+	inputMute := map[string]interface{}{
+		"p":             "switch",
+		"name":          "Microphone Mute",
+		"unique_id":     app.hostname + "_audio_input_mute",
+		"command_topic": app.getTopicPrefix() + "/command/audio/input_mute",
+		"payload_on":    "true",
+		"payload_off":   "false",
+		"state_topic":   app.getTopicPrefix() + "/status/audio/input_mute",
+		"icon":          "mdi:microphone-off",
+	}
+
 	battery := map[string]interface{}{
 		"p":                   "sensor",
 		"name":                "Battery",
@@ -1885,6 +6534,118 @@ func (app *Application) setDevice(client mqtt.Client) {
 		"device_class":        "battery",
 	}
 
+	batteryCharging := map[string]interface{}{
+		"p":                  "binary_sensor",
+		"name":               "Battery Charging",
+		"unique_id":          app.hostname + "_battery_charging",
+		"state_topic":        app.getTopicPrefix() + "/status/battery/charging",
+		"payload_on":         "true",
+		"payload_off":        "false",
+		"enabled_by_default": false,
+		"device_class":       "battery_charging",
+	}
+
+	batteryState := map[string]interface{}{
+		"p":                  "sensor",
+		"name":               "Battery State",
+		"unique_id":          app.hostname + "_battery_state",
+		"state_topic":        app.getTopicPrefix() + "/status/battery/state",
+		"enabled_by_default": false,
+		"icon":               "mdi:battery-charging",
+	}
+
+	powerSource := map[string]interface{}{
+		"p":           "sensor",
+		"name":        "Power Source",
+		"unique_id":   app.hostname + "_power_source",
+		"state_topic": app.getTopicPrefix() + "/status/battery/power_source",
+		"icon":        "mdi:power-plug",
+	}
+
+	batteryTimeRemaining := map[string]interface{}{
+		"p":                   "sensor",
+		"name":                "Battery Time Remaining",
+		"unique_id":           app.hostname + "_battery_time_remaining",
+		"state_topic":         app.getTopicPrefix() + "/status/battery/time_remaining",
+		"enabled_by_default":  false,
+		"unit_of_measurement": "min",
+		"device_class":        "duration",
+		"icon":                "mdi:battery-clock",
+	}
+
+	batteryCycleCount := map[string]interface{}{
+		"p":                  "sensor",
+		"name":               "Battery Cycle Count",
+		"unique_id":          app.hostname + "_battery_cycle_count",
+		"state_topic":        app.getTopicPrefix() + "/status/battery/cycle_count",
+		"enabled_by_default": false,
+		"state_class":        "total_increasing",
+		"icon":               "mdi:battery-sync",
+	}
+
+	batteryCondition := map[string]interface{}{
+		"p":                  "sensor",
+		"name":               "Battery Condition",
+		"unique_id":          app.hostname + "_battery_condition",
+		"state_topic":        app.getTopicPrefix() + "/status/battery/condition",
+		"enabled_by_default": false,
+		"icon":               "mdi:battery-heart-variant",
+	}
+
+	batteryDesignCapacity := map[string]interface{}{
+		"p":                   "sensor",
+		"name":                "Battery Design Capacity",
+		"unique_id":           app.hostname + "_battery_design_capacity",
+		"state_topic":         app.getTopicPrefix() + "/status/battery/design_capacity",
+		"enabled_by_default":  false,
+		"unit_of_measurement": "mAh",
+		"icon":                "mdi:battery",
+	}
+
+	batteryMaxCapacity := map[string]interface{}{
+		"p":                   "sensor",
+		"name":                "Battery Max Capacity",
+		"unique_id":           app.hostname + "_battery_max_capacity",
+		"state_topic":         app.getTopicPrefix() + "/status/battery/max_capacity",
+		"enabled_by_default":  false,
+		"unit_of_measurement": "mAh",
+		"state_class":         "measurement",
+		"icon":                "mdi:battery",
+	}
+
+	batteryHealth := map[string]interface{}{
+		"p":                   "sensor",
+		"name":                "Battery Health",
+		"unique_id":           app.hostname + "_battery_health",
+		"state_topic":         app.getTopicPrefix() + "/status/battery/health",
+		"enabled_by_default":  false,
+		"unit_of_measurement": "%",
+		"state_class":         "measurement",
+		"icon":                "mdi:battery-heart-variant",
+	}
+
+	batteryTemperature := map[string]interface{}{
+		"p":                   "sensor",
+		"name":                "Battery Temperature",
+		"unique_id":           app.hostname + "_battery_temperature",
+		"state_topic":         app.getTopicPrefix() + "/status/battery/temperature",
+		"enabled_by_default":  false,
+		"unit_of_measurement": "°C",
+		"device_class":        "temperature",
+		"state_class":         "measurement",
+	}
+
+	adapterWatts := map[string]interface{}{
+		"p":                   "sensor",
+		"name":                "Power Adapter",
+		"unique_id":           app.hostname + "_adapter_watts",
+		"state_topic":         app.getTopicPrefix() + "/status/battery/adapter_watts",
+		"enabled_by_default":  false,
+		"unit_of_measurement": "W",
+		"device_class":        "power",
+		"state_class":         "measurement",
+	}
+
 	diskTotal := map[string]interface{}{
 		"p":                   "sensor",
 		"name":                "Disk Total",
@@ -1955,58 +6716,209 @@ func (app *Application) setDevice(client mqtt.Client) {
 		"state_topic":         app.getTopicPrefix() + "/status/cpu/free_percent",
 		"unit_of_measurement": "%",
 		"state_class":         "measurement",
-		"icon":                "mdi:cpu-64-bit",
+		"icon":                "mdi:cpu-64-bit",
+	}
+
+	cpuUserPercent := map[string]interface{}{
+		"p":                   "sensor",
+		"name":                "CPU User Percent",
+		"unique_id":           app.hostname + "_cpu_user_percent",
+		"state_topic":         app.getTopicPrefix() + "/status/cpu/user_percent",
+		"enabled_by_default":  false,
+		"unit_of_measurement": "%",
+		"state_class":         "measurement",
+		"icon":                "mdi:cpu-64-bit",
+	}
+
+	cpuSystemPercent := map[string]interface{}{
+		"p":                   "sensor",
+		"name":                "CPU System Percent",
+		"unique_id":           app.hostname + "_cpu_system_percent",
+		"state_topic":         app.getTopicPrefix() + "/status/cpu/system_percent",
+		"enabled_by_default":  false,
+		"unit_of_measurement": "%",
+		"state_class":         "measurement",
+		"icon":                "mdi:cpu-64-bit",
+	}
+
+	cpuIowaitPercent := map[string]interface{}{
+		"p":                   "sensor",
+		"name":                "CPU IO Wait Percent",
+		"unique_id":           app.hostname + "_cpu_iowait_percent",
+		"state_topic":         app.getTopicPrefix() + "/status/cpu/iowait_percent",
+		"enabled_by_default":  false,
+		"unit_of_measurement": "%",
+		"state_class":         "measurement",
+		"icon":                "mdi:cpu-64-bit",
+	}
+
+	load1 := map[string]interface{}{
+		"p":           "sensor",
+		"name":        "Load Average 1m",
+		"unique_id":   app.hostname + "_cpu_load_1",
+		"state_topic": app.getTopicPrefix() + "/status/cpu/load_1",
+		"state_class": "measurement",
+		"icon":        "mdi:gauge",
+	}
+
+	load5 := map[string]interface{}{
+		"p":           "sensor",
+		"name":        "Load Average 5m",
+		"unique_id":   app.hostname + "_cpu_load_5",
+		"state_topic": app.getTopicPrefix() + "/status/cpu/load_5",
+		"state_class": "measurement",
+		"icon":        "mdi:gauge",
+	}
+
+	load15 := map[string]interface{}{
+		"p":           "sensor",
+		"name":        "Load Average 15m",
+		"unique_id":   app.hostname + "_cpu_load_15",
+		"state_topic": app.getTopicPrefix() + "/status/cpu/load_15",
+		"state_class": "measurement",
+		"icon":        "mdi:gauge",
+	}
+
+	memoryTotal := map[string]interface{}{
+		"p":                   "sensor",
+		"name":                "Memory Total",
+		"unique_id":           app.hostname + "_memory_total",
+		"state_topic":         app.getTopicPrefix() + "/status/memory/total",
+		"unit_of_measurement": "B",
+		"device_class":        "data_size",
+		"state_class":         "measurement",
+		"icon":                "mdi:memory",
+	}
+
+	memoryUsed := map[string]interface{}{
+		"p":                   "sensor",
+		"name":                "Memory Used",
+		"unique_id":           app.hostname + "_memory_used",
+		"state_topic":         app.getTopicPrefix() + "/status/memory/used",
+		"unit_of_measurement": "B",
+		"device_class":        "data_size",
+		"state_class":         "measurement",
+		"icon":                "mdi:memory",
+	}
+
+	memoryFree := map[string]interface{}{
+		"p":                   "sensor",
+		"name":                "Memory Free",
+		"unique_id":           app.hostname + "_memory_free",
+		"state_topic":         app.getTopicPrefix() + "/status/memory/free",
+		"unit_of_measurement": "B",
+		"device_class":        "data_size",
+		"state_class":         "measurement",
+		"icon":                "mdi:memory",
+	}
+
+	memoryUsedPercent := map[string]interface{}{
+		"p":                   "sensor",
+		"name":                "Memory Used Percent",
+		"unique_id":           app.hostname + "_memory_used_percent",
+		"state_topic":         app.getTopicPrefix() + "/status/memory/used_percent",
+		"unit_of_measurement": "%",
+		"state_class":         "measurement",
+		"icon":                "mdi:memory",
+	}
+
+	memoryFreePercent := map[string]interface{}{
+		"p":                   "sensor",
+		"name":                "Memory Free Percent",
+		"unique_id":           app.hostname + "_memory_free_percent",
+		"state_topic":         app.getTopicPrefix() + "/status/memory/free_percent",
+		"unit_of_measurement": "%",
+		"state_class":         "measurement",
+		"icon":                "mdi:memory",
+	}
+
+	memoryPressure := map[string]interface{}{
+		"p":            "sensor",
+		"name":         "Memory Pressure",
+		"unique_id":    app.hostname + "_memory_pressure",
+		"state_topic":  app.getTopicPrefix() + "/status/memory/pressure",
+		"device_class": "enum",
+		"options":      []string{"normal", "warn", "critical"},
+		"icon":         "mdi:memory",
+	}
+
+	swapUsed := map[string]interface{}{
+		"p":                   "sensor",
+		"name":                "Swap Used",
+		"unique_id":           app.hostname + "_memory_swap_used",
+		"state_topic":         app.getTopicPrefix() + "/status/memory/swap_used",
+		"unit_of_measurement": "B",
+		"device_class":        "data_size",
+		"state_class":         "measurement",
+		"icon":                "mdi:swap-horizontal",
 	}
 
-	memoryTotal := map[string]interface{}{
+	swapTotal := map[string]interface{}{
 		"p":                   "sensor",
-		"name":                "Memory Total",
-		"unique_id":           app.hostname + "_memory_total",
-		"state_topic":         app.getTopicPrefix() + "/status/memory/total",
+		"name":                "Swap Total",
+		"unique_id":           app.hostname + "_memory_swap_total",
+		"state_topic":         app.getTopicPrefix() + "/status/memory/swap_total",
+		"enabled_by_default":  false,
 		"unit_of_measurement": "B",
 		"device_class":        "data_size",
 		"state_class":         "measurement",
-		"icon":                "mdi:memory",
+		"icon":                "mdi:swap-horizontal",
 	}
 
-	memoryUsed := map[string]interface{}{
+	swapUsedPercent := map[string]interface{}{
 		"p":                   "sensor",
-		"name":                "Memory Used",
-		"unique_id":           app.hostname + "_memory_used",
-		"state_topic":         app.getTopicPrefix() + "/status/memory/used",
+		"name":                "Swap Used Percent",
+		"unique_id":           app.hostname + "_memory_swap_used_percent",
+		"state_topic":         app.getTopicPrefix() + "/status/memory/swap_used_percent",
+		"enabled_by_default":  false,
+		"unit_of_measurement": "%",
+		"state_class":         "measurement",
+		"icon":                "mdi:swap-horizontal",
+	}
+
+	memoryCompressed := map[string]interface{}{
+		"p":                   "sensor",
+		"name":                "Memory Compressed",
+		"unique_id":           app.hostname + "_memory_compressed",
+		"state_topic":         app.getTopicPrefix() + "/status/memory/compressed",
 		"unit_of_measurement": "B",
 		"device_class":        "data_size",
 		"state_class":         "measurement",
 		"icon":                "mdi:memory",
 	}
 
-	memoryFree := map[string]interface{}{
+	memoryWired := map[string]interface{}{
 		"p":                   "sensor",
-		"name":                "Memory Free",
-		"unique_id":           app.hostname + "_memory_free",
-		"state_topic":         app.getTopicPrefix() + "/status/memory/free",
+		"name":                "Memory Wired",
+		"unique_id":           app.hostname + "_memory_wired",
+		"state_topic":         app.getTopicPrefix() + "/status/memory/wired",
+		"enabled_by_default":  false,
 		"unit_of_measurement": "B",
 		"device_class":        "data_size",
 		"state_class":         "measurement",
 		"icon":                "mdi:memory",
 	}
 
-	memoryUsedPercent := map[string]interface{}{
+	memoryActive := map[string]interface{}{
 		"p":                   "sensor",
-		"name":                "Memory Used Percent",
-		"unique_id":           app.hostname + "_memory_used_percent",
-		"state_topic":         app.getTopicPrefix() + "/status/memory/used_percent",
-		"unit_of_measurement": "%",
+		"name":                "Memory Active",
+		"unique_id":           app.hostname + "_memory_active",
+		"state_topic":         app.getTopicPrefix() + "/status/memory/active",
+		"enabled_by_default":  false,
+		"unit_of_measurement": "B",
+		"device_class":        "data_size",
 		"state_class":         "measurement",
 		"icon":                "mdi:memory",
 	}
 
-	memoryFreePercent := map[string]interface{}{
+	memoryInactive := map[string]interface{}{
 		"p":                   "sensor",
-		"name":                "Memory Free Percent",
-		"unique_id":           app.hostname + "_memory_free_percent",
-		"state_topic":         app.getTopicPrefix() + "/status/memory/free_percent",
-		"unit_of_measurement": "%",
+		"name":                "Memory Inactive",
+		"unique_id":           app.hostname + "_memory_inactive",
+		"state_topic":         app.getTopicPrefix() + "/status/memory/inactive",
+		"enabled_by_default":  false,
+		"unit_of_measurement": "B",
+		"device_class":        "data_size",
 		"state_class":         "measurement",
 		"icon":                "mdi:memory",
 	}
@@ -2052,6 +6964,95 @@ func (app *Application) setDevice(client mqtt.Client) {
 		"device_class": "running",
 	}
 
+	networkInterface := map[string]interface{}{
+		"p":           "sensor",
+		"name":        "Network Interface",
+		"unique_id":   app.hostname + "_network_interface",
+		"state_topic": app.getTopicPrefix() + "/status/network/interface",
+		"icon":        "mdi:lan",
+	}
+
+	networkInterfaceType := map[string]interface{}{
+		"p":           "sensor",
+		"name":        "Network Connection",
+		"unique_id":   app.hostname + "_network_interface_type",
+		"state_topic": app.getTopicPrefix() + "/status/network/interface_type",
+		"icon":        "mdi:lan-connect",
+	}
+
+	localIPv4 := map[string]interface{}{
+		"p":           "sensor",
+		"name":        "Local IPv4",
+		"unique_id":   app.hostname + "_local_ipv4",
+		"state_topic": app.getTopicPrefix() + "/status/network/ipv4",
+		"icon":        "mdi:ip-network",
+	}
+
+	localIPv6 := map[string]interface{}{
+		"p":                  "sensor",
+		"name":               "Local IPv6",
+		"unique_id":          app.hostname + "_local_ipv6",
+		"state_topic":        app.getTopicPrefix() + "/status/network/ipv6",
+		"enabled_by_default": false,
+		"icon":               "mdi:ip-network",
+	}
+
+	gateway := map[string]interface{}{
+		"p":           "sensor",
+		"name":        "Default Gateway",
+		"unique_id":   app.hostname + "_gateway",
+		"state_topic": app.getTopicPrefix() + "/status/network/gateway",
+		"icon":        "mdi:router-network",
+	}
+
+	ethernet := map[string]interface{}{
+		"p":            "binary_sensor",
+		"name":         "Ethernet",
+		"unique_id":    app.hostname + "_ethernet",
+		"state_topic":  app.getTopicPrefix() + "/status/network/ethernet",
+		"payload_on":   "true",
+		"payload_off":  "false",
+		"device_class": "connectivity",
+		"icon":         "mdi:ethernet",
+	}
+
+	wifiSSID := map[string]interface{}{
+		"p":           "sensor",
+		"name":        "Wi-Fi SSID",
+		"unique_id":   app.hostname + "_wifi_ssid",
+		"state_topic": app.getTopicPrefix() + "/status/network/wifi/ssid",
+		"icon":        "mdi:wifi",
+	}
+
+	wifiBSSID := map[string]interface{}{
+		"p":                  "sensor",
+		"name":               "Wi-Fi BSSID",
+		"unique_id":          app.hostname + "_wifi_bssid",
+		"state_topic":        app.getTopicPrefix() + "/status/network/wifi/bssid",
+		"enabled_by_default": false,
+		"icon":               "mdi:access-point",
+	}
+
+	wifiRSSI := map[string]interface{}{
+		"p":                   "sensor",
+		"name":                "Wi-Fi Signal",
+		"unique_id":           app.hostname + "_wifi_rssi",
+		"state_topic":         app.getTopicPrefix() + "/status/network/wifi/rssi",
+		"unit_of_measurement": "dBm",
+		"device_class":        "signal_strength",
+		"state_class":         "measurement",
+		"icon":                "mdi:wifi-strength-2",
+	}
+
+	wifiChannel := map[string]interface{}{
+		"p":                  "sensor",
+		"name":               "Wi-Fi Channel",
+		"unique_id":          app.hostname + "_wifi_channel",
+		"state_topic":        app.getTopicPrefix() + "/status/network/wifi/channel",
+		"enabled_by_default": false,
+		"icon":               "mdi:wifi-settings",
+	}
+
 	publicIP := map[string]interface{}{
 		"p":           "sensor",
 		"name":        "Public IP",
@@ -2061,32 +7062,76 @@ func (app *Application) setDevice(client mqtt.Client) {
 	}
 
 	components := map[string]interface{}{
-		"sleep":               sleep,
-		"shutdown":            shutdown,
-		"volume":              volume,
-		"mute":                mute,
-		"displaywake":         displaywake,
-		"displaysleep":        displaysleep,
-		"screensaver":         screensaver,
-		"battery":             battery,
-		"keepawake":           keepawake,
-		"disk_total":          diskTotal,
-		"disk_used":           diskUsed,
-		"disk_free":           diskFree,
-		"disk_used_percent":   diskUsedPercent,
-		"disk_free_percent":   diskFreePercent,
-		"cpu_used_percent":    cpuUsedPercent,
-		"cpu_free_percent":    cpuFreePercent,
-		"memory_total":        memoryTotal,
-		"memory_used":         memoryUsed,
-		"memory_free":         memoryFree,
-		"memory_used_percent": memoryUsedPercent,
-		"memory_free_percent": memoryFreePercent,
-		"uptime_seconds":      uptimeSeconds,
-		"uptime_human":        uptimeHuman,
-		"microphone":          microphone,
-		"camera":              camera,
-		"public_ip":           publicIP,
+		"sleep":                    sleep,
+		"shutdown":                 shutdown,
+		"restart":                  restart,
+		"logout":                   logout,
+		"system_result":            systemResult,
+		"scheduled_action":         scheduledAction,
+		"scheduled_action_at":      scheduledActionAt,
+		"cancel_scheduled_action":  cancelScheduledAction,
+		"volume":                   volume,
+		"audio_input_volume":       inputVolume,
+		"audio_input_mute":         inputMute,
+		"mute":                     mute,
+		"displaywake":              displaywake,
+		"displaysleep":             displaysleep,
+		"screensaver":              screensaver,
+		"battery":                  battery,
+		"battery_charging":         batteryCharging,
+		"battery_state":            batteryState,
+		"power_source":             powerSource,
+		"battery_time_remaining":   batteryTimeRemaining,
+		"battery_cycle_count":      batteryCycleCount,
+		"battery_condition":        batteryCondition,
+		"battery_design_capacity":  batteryDesignCapacity,
+		"battery_max_capacity":     batteryMaxCapacity,
+		"battery_health":           batteryHealth,
+		"battery_temperature":      batteryTemperature,
+		"adapter_watts":            adapterWatts,
+		"keepawake":                keepawake,
+		"keepawake_remaining":      keepAwakeRemaining,
+		"disk_total":               diskTotal,
+		"disk_used":                diskUsed,
+		"disk_free":                diskFree,
+		"disk_used_percent":        diskUsedPercent,
+		"disk_free_percent":        diskFreePercent,
+		"cpu_used_percent":         cpuUsedPercent,
+		"cpu_free_percent":         cpuFreePercent,
+		"cpu_user_percent":         cpuUserPercent,
+		"cpu_system_percent":       cpuSystemPercent,
+		"cpu_iowait_percent":       cpuIowaitPercent,
+		"cpu_load_1":               load1,
+		"cpu_load_5":               load5,
+		"cpu_load_15":              load15,
+		"memory_total":             memoryTotal,
+		"memory_used":              memoryUsed,
+		"memory_free":              memoryFree,
+		"memory_used_percent":      memoryUsedPercent,
+		"memory_free_percent":      memoryFreePercent,
+		"memory_pressure":          memoryPressure,
+		"memory_swap_used":         swapUsed,
+		"memory_swap_total":        swapTotal,
+		"memory_swap_used_percent": swapUsedPercent,
+		"memory_compressed":        memoryCompressed,
+		"memory_wired":             memoryWired,
+		"memory_active":            memoryActive,
+		"memory_inactive":          memoryInactive,
+		"uptime_seconds":           uptimeSeconds,
+		"uptime_human":             uptimeHuman,
+		"microphone":               microphone,
+		"camera":                   camera,
+		"public_ip":                publicIP,
+		"network_interface":        networkInterface,
+		"network_interface_type":   networkInterfaceType,
+		"local_ipv4":               localIPv4,
+		"local_ipv6":               localIPv6,
+		"gateway":                  gateway,
+		"ethernet":                 ethernet,
+		"wifi_ssid":                wifiSSID,
+		"wifi_bssid":               wifiBSSID,
+		"wifi_rssi":                wifiRSSI,
+		"wifi_channel":             wifiChannel,
 	}
 
 	// Add user activity sensor
@@ -2115,6 +7160,473 @@ func (app *Application) setDevice(client mqtt.Client) {
 	}
 	components["idle_time_seconds"] = idleTime
 
+	// Add usage sensors for each mounted volume
+	app.diskMutex.Lock()
+	for _, volume := range app.diskVolumes {
+		prefix := app.getTopicPrefix() + "/status/disks/" + volume.Slug
+		for _, metric := range []struct{ key, name, unit, deviceClass, icon string }{
+			{"total", "Total", "B", "data_size", "mdi:harddisk"},
+			{"used", "Used", "B", "data_size", "mdi:harddisk"},
+			{"free", "Free", "B", "data_size", "mdi:harddisk"},
+			{"used_percent", "Used Percent", "%", "", "mdi:chart-pie"},
+		} {
+			component := map[string]interface{}{
+				"p":                     "sensor",
+				"name":                  "Disk " + volume.Name + " " + metric.name,
+				"unique_id":             app.hostname + "_disks_" + volume.Slug + "_" + metric.key,
+				"state_topic":           prefix + "/" + metric.key,
+				"json_attributes_topic": prefix + "/attr",
+				"unit_of_measurement":   metric.unit,
+				"state_class":           "measurement",
+				"icon":                  metric.icon,
+			}
+			if metric.deviceClass != "" {
+				component["device_class"] = metric.deviceClass
+			}
+			components["disks_"+volume.Slug+"_"+metric.key] = component
+		}
+		if volume.isEjectable() {
+			components["disks_"+volume.Slug+"_eject"] = map[string]interface{}{
+				"p":             "button",
+				"name":          "Eject " + volume.Name,
+				"unique_id":     app.hostname + "_disks_" + volume.Slug + "_eject",
+				"command_topic": app.getTopicPrefix() + "/command/eject",
+				"payload_press": volume.Slug,
+				"icon":          "mdi:eject",
+			}
+		}
+	}
+	// Components published with only a platform are removed by Home Assistant
+	for _, slug := range app.removedDiskSlugs {
+		for _, key := range []string{"total", "used", "free", "used_percent"} {
+			if _, ok := components["disks_"+slug+"_"+key]; !ok {
+				components["disks_"+slug+"_"+key] = map[string]interface{}{"p": "sensor"}
+			}
+		}
+		if _, ok := components["disks_"+slug+"_eject"]; !ok {
+			components["disks_"+slug+"_eject"] = map[string]interface{}{"p": "button"}
+		}
+	}
+	app.removedDiskSlugs = nil
+	app.diskMutex.Unlock()
+
+	components["volume_event"] = map[string]interface{}{
+		"p":           "event",
+		"name":        "Volume",
+		"unique_id":   app.hostname + "_volume_event",
+		"state_topic": app.getTopicPrefix() + "/event/volume",
+		"event_types": []string{"mounted", "unmounted", "ejected", "eject_failed"},
+		"icon":        "mdi:harddisk",
+	}
+
+	// Add throughput sensors for each matching interface
+	app.netMutex.Lock()
+	for name := range app.lastNetIO {
+		prefix := app.getTopicPrefix() + "/status/network/" + name
+		for _, metric := range []struct{ key, name, unit, deviceClass, icon string }{
+			{"bytes_in", "In", "B/s", "data_rate", "mdi:download-network"},
+			{"bytes_out", "Out", "B/s", "data_rate", "mdi:upload-network"},
+			{"packets_in", "Packets In", "packets/s", "", "mdi:download-network-outline"},
+			{"packets_out", "Packets Out", "packets/s", "", "mdi:upload-network-outline"},
+		} {
+			component := map[string]interface{}{
+				"p":                   "sensor",
+				"name":                name + " " + metric.name,
+				"unique_id":           app.hostname + "_network_" + name + "_" + metric.key,
+				"state_topic":         prefix + "/" + metric.key,
+				"unit_of_measurement": metric.unit,
+				"state_class":         "measurement",
+				"icon":                metric.icon,
+			}
+			if metric.deviceClass != "" {
+				component["device_class"] = metric.deviceClass
+			} else {
+				component["enabled_by_default"] = false
+			}
+			components["network_"+name+"_"+metric.key] = component
+		}
+	}
+	app.netMutex.Unlock()
+
+	// Add latency and packet loss sensors for each probe
+	for _, probe := range app.config.Network.Probes {
+		prefix := app.getTopicPrefix() + "/status/probe/" + probe.Name
+		components["probe_"+probe.Name+"_latency"] = map[string]interface{}{
+			"p":                   "sensor",
+			"name":                "Probe " + probe.Name + " Latency",
+			"unique_id":           app.hostname + "_probe_" + probe.Name + "_latency",
+			"state_topic":         prefix + "/latency",
+			"unit_of_measurement": "ms",
+			"device_class":        "duration",
+			"state_class":         "measurement",
+			"icon":                "mdi:timer-outline",
+		}
+		components["probe_"+probe.Name+"_loss"] = map[string]interface{}{
+			"p":                   "sensor",
+			"name":                "Probe " + probe.Name + " Packet Loss",
+			"unique_id":           app.hostname + "_probe_" + probe.Name + "_loss",
+			"state_topic":         prefix + "/loss",
+			"unit_of_measurement": "%",
+			"state_class":         "measurement",
+			"icon":                "mdi:lan-disconnect",
+		}
+	}
+
+	if app.config.PublicIP.IPv6 {
+		components["public_ipv6"] = map[string]interface{}{
+			"p":           "sensor",
+			"name":        "Public IPv6",
+			"unique_id":   app.hostname + "_public_ipv6",
+			"state_topic": app.getTopicPrefix() + "/status/public_ipv6",
+			"icon":        "mdi:ip-network",
+		}
+	}
+	components["public_ip_event"] = map[string]interface{}{
+		"p":           "event",
+		"name":        "Public IP",
+		"unique_id":   app.hostname + "_public_ip_event",
+		"state_topic": app.getTopicPrefix() + "/event/public_ip",
+		"event_types": []string{"changed"},
+		"icon":        "mdi:ip-network",
+	}
+
+	// Add optional CPU sensors depending on the processor and configuration
+	if app.cpuTopology.FrequencyMHz > 0 {
+		components["cpu_frequency"] = map[string]interface{}{
+			"p":                   "sensor",
+			"name":                "CPU Frequency",
+			"unique_id":           app.hostname + "_cpu_frequency",
+			"state_topic":         app.getTopicPrefix() + "/status/cpu/frequency",
+			"unit_of_measurement": "MHz",
+			"device_class":        "frequency",
+			"enabled_by_default":  false,
+			"icon":                "mdi:speedometer",
+		}
+	}
+	if app.cpuTopology.EfficiencyCores > 0 {
+		components["cpu_performance_percent"] = map[string]interface{}{
+			"p":                   "sensor",
+			"name":                fmt.Sprintf("CPU Performance Cores (%d)", app.cpuTopology.PerformanceCores),
+			"unique_id":           app.hostname + "_cpu_performance_percent",
+			"state_topic":         app.getTopicPrefix() + "/status/cpu/performance_percent",
+			"unit_of_measurement": "%",
+			"state_class":         "measurement",
+			"icon":                "mdi:cpu-64-bit",
+		}
+		components["cpu_efficiency_percent"] = map[string]interface{}{
+			"p":                   "sensor",
+			"name":                fmt.Sprintf("CPU Efficiency Cores (%d)", app.cpuTopology.EfficiencyCores),
+			"unique_id":           app.hostname + "_cpu_efficiency_percent",
+			"state_topic":         app.getTopicPrefix() + "/status/cpu/efficiency_percent",
+			"unit_of_measurement": "%",
+			"state_class":         "measurement",
+			"icon":                "mdi:cpu-64-bit",
+		}
+	}
+	if app.config.CPU.PerCore {
+		app.cpuMutex.RLock()
+		for i := range app.lastCPUList.List {
+			components[fmt.Sprintf("cpu_core_%d_used_percent", i)] = map[string]interface{}{
+				"p":                   "sensor",
+				"name":                fmt.Sprintf("CPU Core %d", i),
+				"unique_id":           fmt.Sprintf("%s_cpu_core_%d_used_percent", app.hostname, i),
+				"state_topic":         fmt.Sprintf("%s/status/cpu/core/%d/used_percent", app.getTopicPrefix(), i),
+				"unit_of_measurement": "%",
+				"state_class":         "measurement",
+				"icon":                "mdi:cpu-64-bit",
+			}
+		}
+		app.cpuMutex.RUnlock()
+	}
+
+	if app.config.Processes.Enabled {
+		components["processes_top_cpu"] = map[string]interface{}{
+			"p":                     "sensor",
+			"name":                  "Top Process CPU",
+			"unique_id":             app.hostname + "_processes_top_cpu",
+			"state_topic":           app.getTopicPrefix() + "/status/processes/top_cpu",
+			"json_attributes_topic": app.getTopicPrefix() + "/status/processes/top_cpu/attr",
+			"icon":                  "mdi:application-cog",
+		}
+		components["processes_top_memory"] = map[string]interface{}{
+			"p":                     "sensor",
+			"name":                  "Top Process Memory",
+			"unique_id":             app.hostname + "_processes_top_memory",
+			"state_topic":           app.getTopicPrefix() + "/status/processes/top_memory",
+			"json_attributes_topic": app.getTopicPrefix() + "/status/processes/top_memory/attr",
+			"icon":                  "mdi:application-cog",
+		}
+	}
+
+	for key, button := range volumeButtons {
+		components[key] = button
+	}
+
+	// Add each custom sensor from the config
+	for _, sensor := range app.config.Sensors {
+		component := map[string]interface{}{
+			"p":           "sensor",
+			"name":        strings.ReplaceAll(sensor.Name, "_", " "),
+			"unique_id":   app.hostname + "_sensor_" + sensor.Name,
+			"state_topic": app.getTopicPrefix() + "/status/sensor/" + sensor.Name,
+		}
+		if sensor.Type == "bool" {
+			component["p"] = "binary_sensor"
+		}
+		for key, value := range map[string]string{
+			"unit_of_measurement": sensor.Unit,
+			"device_class":        sensor.DeviceClass,
+			"state_class":         sensor.StateClass,
+			"icon":                sensor.Icon,
+		} {
+			if value != "" {
+				component[key] = value
+			}
+		}
+		components["sensor_"+sensor.Name] = component
+	}
+
+	// Add a button and a result sensor for each custom command
+	for _, command := range app.config.Commands {
+		// A button press sends no parameters, so only commands that need none get one
+		pressable := true
+		for _, param := range command.Params {
+			if param.Default == "" && !param.Optional {
+				pressable = false
+			}
+		}
+		if pressable {
+			components["custom_"+command.Name] = map[string]interface{}{
+				"p":             "button",
+				"name":          strings.ReplaceAll(command.Name, "_", " "),
+				"unique_id":     app.hostname + "_custom_" + command.Name,
+				"command_topic": app.getTopicPrefix() + "/command/custom/" + command.Name,
+				"payload_press": "",
+				"icon":          "mdi:console",
+			}
+		}
+		components["custom_"+command.Name+"_result"] = map[string]interface{}{
+			"p":                     "sensor",
+			"name":                  strings.ReplaceAll(command.Name, "_", " ") + " Result",
+			"unique_id":             app.hostname + "_custom_" + command.Name + "_result",
+			"state_topic":           app.getTopicPrefix() + "/status/custom/" + command.Name + "/result",
+			"value_template":        "{{ value_json.exit_code }}",
+			"json_attributes_topic": app.getTopicPrefix() + "/status/custom/" + command.Name + "/result",
+			"enabled_by_default":    false,
+			"icon":                  "mdi:console",
+		}
+	}
+
+	components["shortcut_result"] = map[string]interface{}{
+		"p":                     "sensor",
+		"name":                  "Shortcut Result",
+		"unique_id":             app.hostname + "_shortcut_result",
+		"state_topic":           app.getTopicPrefix() + "/status/shortcut/result",
+		"value_template":        "{{ value_json.exit_code }}",
+		"json_attributes_topic": app.getTopicPrefix() + "/status/shortcut/result",
+		"icon":                  "mdi:apple",
+	}
+	app.shortcutMutex.Lock()
+	if len(app.shortcuts) > 0 {
+		components["shortcut_select"] = map[string]interface{}{
+			"p":             "select",
+			"name":          "Shortcut",
+			"unique_id":     app.hostname + "_shortcut_select",
+			"command_topic": app.getTopicPrefix() + "/command/shortcut/select",
+			"state_topic":   app.getTopicPrefix() + "/status/shortcut/selected",
+			"options":       app.shortcuts,
+			"icon":          "mdi:apple",
+		}
+		components["shortcut_run"] = map[string]interface{}{
+			"p":             "button",
+			"name":          "Run Shortcut",
+			"unique_id":     app.hostname + "_shortcut_run",
+			"command_topic": app.getTopicPrefix() + "/command/shortcut/run",
+			"icon":          "mdi:play",
+		}
+	}
+	app.shortcutMutex.Unlock()
+
+	components["notify"] = map[string]interface{}{
+		"p":             "notify",
+		"name":          "Notification",
+		"unique_id":     app.hostname + "_notify",
+		"command_topic": app.getTopicPrefix() + "/command/notify",
+		"icon":          "mdi:message-badge",
+	}
+	components["notification_event"] = map[string]interface{}{
+		"p":           "event",
+		"name":        "Notification",
+		"unique_id":   app.hostname + "_notification_event",
+		"state_topic": app.getTopicPrefix() + "/event/notification",
+		"event_types": []string{"action", "clicked", "replied", "closed", "timeout", "delivered", "failed"},
+		"icon":        "mdi:message-reply-text",
+	}
+
+	components["confirm_event"] = map[string]interface{}{
+		"p":           "event",
+		"name":        "Confirmation",
+		"unique_id":   app.hostname + "_confirm_event",
+		"state_topic": app.getTopicPrefix() + "/event/confirm",
+		"event_types": []string{"armed", "confirmed", "cancelled", "expired"},
+		"icon":        "mdi:shield-alert-outline",
+	}
+
+	components["say"] = map[string]interface{}{
+		"p":             "text",
+		"name":          "Say",
+		"unique_id":     app.hostname + "_say",
+		"command_topic": app.getTopicPrefix() + "/command/say",
+		"max":           255,
+		"icon":          "mdi:account-voice",
+	}
+	if sounds := getSystemSounds(); len(sounds) > 0 {
+		components["sound"] = map[string]interface{}{
+			"p":             "select",
+			"name":          "Play Sound",
+			"unique_id":     app.hostname + "_sound",
+			"command_topic": app.getTopicPrefix() + "/command/sound",
+			"options":       sounds,
+			"icon":          "mdi:bell-ring",
+		}
+	}
+
+	// Add output and input device selects with the current device lists
+	app.audioMutex.Lock()
+	if app.audioDevices != nil {
+		components["audio_device_event"] = map[string]interface{}{
+			"p":           "event",
+			"name":        "Audio Device",
+			"unique_id":   app.hostname + "_audio_device_event",
+			"state_topic": app.getTopicPrefix() + "/event/audio_device",
+			"event_types": []string{"added", "removed", "output_changed", "input_changed"},
+			"icon":        "mdi:speaker-multiple",
+		}
+		for _, sel := range []struct {
+			key, name, icon string
+			options         []string
+		}{
+			{"output", "Audio Output", "mdi:speaker", app.audioDevices.Outputs},
+			{"input", "Audio Input", "mdi:microphone", app.audioDevices.Inputs},
+		} {
+			if len(sel.options) == 0 {
+				continue
+			}
+			components["audio_"+sel.key] = map[string]interface{}{
+				"p":             "select",
+				"name":          sel.name,
+				"unique_id":     app.hostname + "_audio_" + sel.key,
+				"command_topic": app.getTopicPrefix() + "/command/audio/" + sel.key,
+				"state_topic":   app.getTopicPrefix() + "/status/audio/" + sel.key,
+				"options":       sel.options,
+				"icon":          sel.icon,
+			}
+		}
+	}
+	app.audioMutex.Unlock()
+
+	// Add thermal sensors for whatever the thermal backend reports
+	app.thermalMutex.RLock()
+	if app.thermalInfo != nil {
+		thermalPrefix := app.getTopicPrefix() + "/status/thermal"
+		if app.thermalInfo.Pressure != "" {
+			components["thermal_pressure"] = map[string]interface{}{
+				"p":            "sensor",
+				"name":         "Thermal Pressure",
+				"unique_id":    app.hostname + "_thermal_pressure",
+				"state_topic":  thermalPrefix + "/pressure",
+				"device_class": "enum",
+				"options":      thermalPressureLevels,
+				"icon":         "mdi:thermometer-alert",
+			}
+		}
+		for _, sensor := range []struct {
+			key, name string
+			value     *float64
+		}{
+			{"cpu_temperature", "CPU Temperature", app.thermalInfo.CPUTemperature},
+			{"gpu_temperature", "GPU Temperature", app.thermalInfo.GPUTemperature},
+		} {
+			if sensor.value == nil {
+				continue
+			}
+			components["thermal_"+sensor.key] = map[string]interface{}{
+				"p":                   "sensor",
+				"name":                sensor.name,
+				"unique_id":           app.hostname + "_thermal_" + sensor.key,
+				"state_topic":         thermalPrefix + "/" + sensor.key,
+				"unit_of_measurement": "°C",
+				"device_class":        "temperature",
+				"state_class":         "measurement",
+			}
+		}
+		for i := range app.thermalInfo.Fans {
+			components[fmt.Sprintf("thermal_fan_%d_rpm", i)] = map[string]interface{}{
+				"p":                   "sensor",
+				"name":                fmt.Sprintf("Fan %d", i),
+				"unique_id":           fmt.Sprintf("%s_thermal_fan_%d_rpm", app.hostname, i),
+				"state_topic":         fmt.Sprintf("%s/fan/%d/rpm", thermalPrefix, i),
+				"unit_of_measurement": "RPM",
+				"state_class":         "measurement",
+				"icon":                "mdi:fan",
+			}
+		}
+	}
+	app.thermalMutex.RUnlock()
+
+	// Add a running sensor and command buttons for each watched application
+	for _, watched := range app.config.Apps {
+		slug := watched.Slug()
+		components["app_"+slug+"_running"] = map[string]interface{}{
+			"p":            "binary_sensor",
+			"name":         watched.Name + " Running",
+			"unique_id":    app.hostname + "_app_" + slug + "_running",
+			"state_topic":  app.getTopicPrefix() + "/status/app/" + slug + "/running",
+			"device_class": "running",
+			"icon":         "mdi:application",
+		}
+		for _, command := range []struct{ key, name, icon string }{
+			{"launch", "Launch", "mdi:application-import"},
+			{"quit", "Quit", "mdi:application-export"},
+			{"kill", "Force Quit", "mdi:close-octagon"},
+		} {
+			if !watched.Allows(command.key) {
+				continue
+			}
+			components["app_"+slug+"_"+command.key] = map[string]interface{}{
+				"p":             "button",
+				"name":          command.name + " " + watched.Name,
+				"unique_id":     app.hostname + "_app_" + slug + "_" + command.key,
+				"command_topic": app.getTopicPrefix() + "/command/app/" + slug,
+				"payload_press": command.key,
+				"icon":          command.icon,
+			}
+		}
+	}
+
+	// Add a problem sensor for each configured alert
+	for _, rule := range app.config.Alerts {
+		components["alert_"+rule.Name] = map[string]interface{}{
+			"p":            "binary_sensor",
+			"name":         "Alert " + strings.ReplaceAll(rule.Name, "_", " "),
+			"unique_id":    app.hostname + "_alert_" + rule.Name,
+			"state_topic":  app.getTopicPrefix() + "/status/alert/" + rule.Name,
+			"payload_on":   "ON",
+			"payload_off":  "OFF",
+			"device_class": "problem",
+		}
+	}
+	if len(app.config.Alerts) > 0 {
+		components["alert_event"] = map[string]interface{}{
+			"p":           "event",
+			"name":        "Alert",
+			"unique_id":   app.hostname + "_alert_event",
+			"state_topic": app.getTopicPrefix() + "/event/alert",
+			"event_types": []string{"problem", "resolved"},
+			"icon":        "mdi:alert",
+		}
+	}
+
 	// Add media control components if Media Control is available
 	if isMediaControlAvailable() {
 		playPause := map[string]interface{}{
@@ -2244,10 +7756,27 @@ func (app *Application) Run() error {
 	batteryTicker := time.NewTicker(UpdateInterval)
 	awakeTicker := time.NewTicker(UpdateInterval)
 	networkCheckTicker := time.NewTicker(30 * time.Second) // Check network every 30 seconds
+	volumeWatchTicker := time.NewTicker(VolumeWatchInterval)
 	defer volumeTicker.Stop()
 	defer batteryTicker.Stop()
 	defer awakeTicker.Stop()
 	defer networkCheckTicker.Stop()
+	defer volumeWatchTicker.Stop()
+
+	// Start connectivity probes, they skip runs while MQTT is disconnected
+	app.startProbes(app.client)
+
+	// Start sampling the top processes when enabled
+	app.startProcessMonitor(app.client)
+
+	// Start custom sensors from the config
+	app.startCustomSensors(app.client)
+
+	// Start playing queued announcements
+	app.startAnnouncer(app.client)
+
+	// Restore the system action scheduled before the restart
+	app.loadScheduledAction(app.client)
 
 	// Track connection state
 	lastConnectionState := app.client.IsConnected()
@@ -2259,15 +7788,23 @@ func (app *Application) Run() error {
 		app.updateVolume(app.client)
 		app.updateMute(app.client)
 		app.updateCaffeinateStatus(app.client)
+		app.updateScheduledAction(app.client)
 		app.updateDisplayBrightness(app.client)
 		app.updateNowPlaying(app.client)                 // Initial now playing update
 		app.setUserActivityState(app.client, "inactive") // Initial user activity state
 		app.updateDiskUsage(app.client)                  // Initial disk usage update
+		app.updateVolumeUsage(app.client)                // Initial per-volume disk usage update
 		app.updateCPUUsage(app.client)                   // Initial CPU usage update
 		app.updateMemoryUsage(app.client)                // Initial memory usage update
 		app.updateUptime(app.client)                     // Initial uptime update
 		app.updateMediaDevices(app.client)               // Initial media devices update
 		app.updatePublicIP(app.client)                   // Initial public IP update
+		app.updateNetworkInfo(app.client)                // Initial network interface update
+		app.updateApps(app.client)                       // Initial watched applications update
+		app.updateThermal(app.client)                    // Initial thermal update
+		app.checkAudioDevices(app.client)                // Initial audio devices update
+		app.updateShortcuts(app.client)                  // Initial shortcuts list update
+		app.updateInputVolume(app.client)                // Initial microphone volume update
 
 		// Start media stream for real-time updates
 		app.startMediaStream(app.client)
@@ -2286,6 +7823,7 @@ func (app *Application) Run() error {
 			if app.client.IsConnected() {
 				app.updateVolume(app.client)
 				app.updateMute(app.client)
+				app.updateInputVolume(app.client)
 				app.updateMediaDevices(app.client)
 				app.client.Publish(app.getTopicPrefix()+"/status/alive", 0, true, "online")
 			} else if networkReachable {
@@ -2296,10 +7834,15 @@ func (app *Application) Run() error {
 			if app.client.IsConnected() {
 				app.updateBattery(app.client)
 				app.updateDiskUsage(app.client)
+				app.updateVolumeUsage(app.client)
 				app.updateCPUUsage(app.client)
 				app.updateMemoryUsage(app.client)
 				app.updateUptime(app.client)
 				app.updatePublicIP(app.client)
+				app.updateNetworkInfo(app.client)
+				app.updateNetIO(app.client)
+				app.updateThermal(app.client)
+				app.updateShortcuts(app.client)
 			} else if networkReachable {
 				log.Println("MQTT client not connected but network is reachable, skipping battery update")
 			}
@@ -2307,12 +7850,20 @@ func (app *Application) Run() error {
 		case <-awakeTicker.C:
 			if app.client.IsConnected() {
 				app.updateCaffeinateStatus(app.client)
+				app.updateScheduledAction(app.client)
 				app.updateDisplayBrightness(app.client)
 			} else if networkReachable {
 				log.Println("MQTT client not connected but network is reachable, skipping status updates")
 			}
 			// Note: Media updates now come from the media-control stream
 
+		case <-volumeWatchTicker.C:
+			if app.client.IsConnected() {
+				app.checkVolumeChanges(app.client)
+				app.updateApps(app.client)
+				app.checkAudioDevices(app.client)
+			}
+
 		case <-networkCheckTicker.C:
 			// Periodic network reachability check
 			currentNetworkState := app.isNetworkReachable()
@@ -2390,29 +7941,304 @@ func (app *Application) validateBrightnessInput(payload string) (int, error) {
 	return brightness, nil
 }
 
-// validateShortcutInput validates shortcut input
-func (app *Application) validateShortcutInput(payload string) error {
-	if payload == "" {
-		return fmt.Errorf("shortcut name cannot be empty")
+// validateShortcutInput parses and validates a shortcut payload, either the shortcut name or a JSON request
+func (app *Application) validateShortcutInput(payload string) (*ShortcutRequest, error) {
+	request := &ShortcutRequest{}
+	if strings.HasPrefix(strings.TrimSpace(payload), "{") {
+		if err := json.Unmarshal([]byte(payload), request); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+	} else {
+		request.Name = payload
+	}
+
+	if request.Name == "" {
+		return nil, fmt.Errorf("shortcut name cannot be empty")
+	}
+	// Shortcut names can contain any punctuation or emoji, but never control characters
+	if strings.HasPrefix(request.Name, "-") || strings.IndexFunc(request.Name, unicode.IsControl) >= 0 {
+		return nil, fmt.Errorf("shortcut name contains invalid characters")
+	}
+	if request.Input != "" && request.InputPath != "" {
+		return nil, fmt.Errorf("only one of input and input_path can be set")
+	}
+	if request.InputPath != "" {
+		path, err := resolveOpenPath(request.InputPath, app.config.Open.Roots)
+		if err != nil {
+			return nil, err
+		}
+		request.InputPath = path
+	}
+	return request, nil
+}
+
+// OpenTarget is what the open command opens, exactly one field is set
+type OpenTarget struct {
+	URL      string `json:"url"`
+	Path     string `json:"path"`      // file or folder under one of the allowed roots
+	App      string `json:"app"`       // application name
+	BundleID string `json:"bundle_id"` // application bundle identifier
+}
+
+// validateOpenInput parses and validates an open payload. Plain text is a URL when it has an allowed scheme, a path
+// when it starts with / or ~ and an application name otherwise.
+func (app *Application) validateOpenInput(payload string) (*OpenTarget, error) {
+	target := &OpenTarget{}
+	payload = strings.TrimSpace(payload)
+	switch {
+	case payload == "":
+		return nil, fmt.Errorf("open payload cannot be empty")
+	case strings.HasPrefix(payload, "{"):
+		if err := json.Unmarshal([]byte(payload), target); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+	case strings.HasPrefix(payload, "/") || strings.HasPrefix(payload, "~"):
+		target.Path = payload
+	case strings.Contains(payload, ":"):
+		target.URL = payload
+	default:
+		target.App = payload
+	}
+
+	set := 0
+	for _, field := range []string{target.URL, target.Path, target.App, target.BundleID} {
+		if field != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("exactly one of url, path, app or bundle_id is required")
+	}
+
+	switch {
+	case target.URL != "":
+		u, err := url.Parse(target.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL: %w", err)
+		}
+		allowed := false
+		for _, scheme := range app.config.Open.Schemes {
+			if strings.EqualFold(u.Scheme, scheme) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, fmt.Errorf("URL scheme %q is not allowed", u.Scheme)
+		}
+		if strings.ContainsAny(target.URL, "\x00\r\n") {
+			return nil, fmt.Errorf("URL contains invalid characters")
+		}
+	case target.Path != "":
+		path, err := resolveOpenPath(target.Path, app.config.Open.Roots)
+		if err != nil {
+			return nil, err
+		}
+		target.Path = path
+	case target.App != "":
+		if !regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9\s\-_.]*$`).MatchString(target.App) {
+			return nil, fmt.Errorf("application name contains invalid characters")
+		}
+	case target.BundleID != "":
+		if !regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9\-]*(\.[a-zA-Z0-9\-]+)+$`).MatchString(target.BundleID) {
+			return nil, fmt.Errorf("invalid bundle identifier %q", target.BundleID)
+		}
+	}
+	return target, nil
+}
+
+// resolveOpenPath expands and resolves the path and checks it is inside one of the roots
+func resolveOpenPath(path string, roots []string) (string, error) {
+	if len(roots) == 0 {
+		return "", fmt.Errorf("opening files is disabled, no open.roots are configured")
+	}
+
+	path, err := filepath.EvalSymlinks(expandHome(path))
+	if err != nil {
+		return "", fmt.Errorf("invalid path: %w", err)
+	}
+	for _, root := range roots {
+		root, err := filepath.EvalSymlinks(expandHome(root))
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(root, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("path %s is not under an allowed root", path)
+}
+
+// expandHome replaces a leading ~ with the home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
 	}
-	// Basic validation - shortcut name should be alphanumeric with spaces and hyphens
-	matched, err := regexp.MatchString(`^[a-zA-Z0-9\s\-_]+$`, payload)
+	home, err := os.UserHomeDir()
 	if err != nil {
-		return fmt.Errorf("error validating shortcut name: %w", err)
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
+
+// validateKeepAwakeInput validates keep awake input: true/false, a duration like "2h", or JSON with duration, mode
+// and reason
+func (app *Application) validateKeepAwakeInput(payload string) (*KeepAwakeRequest, error) {
+	request := &KeepAwakeRequest{Enabled: true}
+	payload = strings.TrimSpace(payload)
+	duration := ""
+
+	if strings.HasPrefix(payload, "{") {
+		var body struct {
+			KeepAwakeRequest
+			Duration string `json:"duration"`
+		}
+		if err := json.Unmarshal([]byte(payload), &body); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		request.Mode, request.Reason, duration = body.Mode, body.Reason, body.Duration
+	} else if keepAwake, err := strconv.ParseBool(payload); err == nil {
+		request.Enabled = keepAwake
+	} else {
+		duration = payload
 	}
-	if !matched {
-		return fmt.Errorf("shortcut name contains invalid characters")
+
+	if duration != "" {
+		d, err := time.ParseDuration(duration)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("keep awake must be true, false or a duration like 2h: %q", duration)
+		}
+		request.Duration = d
 	}
-	return nil
+	if request.Mode == "" {
+		request.Mode = app.config.KeepAwakeMode
+	}
+	if _, ok := keepAwakeModes[request.Mode]; !ok {
+		return nil, fmt.Errorf("keep awake mode must be display, idle or system, got %q", request.Mode)
+	}
+	return request, nil
+}
+
+// delayRe matches delays written out like "30 minutes", in addition to Go durations like "30m"
+var delayRe = regexp.MustCompile(`^(\d+)\s*(seconds?|secs?|minutes?|mins?|hours?|hrs?)$`)
+
+// parseDelay parses a delay like "30m", "1h30m" or "30 minutes"
+func parseDelay(s string) (time.Duration, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		m := delayRe.FindStringSubmatch(s)
+		if m == nil {
+			return 0, fmt.Errorf("invalid delay %q, use 30m or 30 minutes", s)
+		}
+		unit := time.Hour
+		switch m[2][0] {
+		case 's':
+			unit = time.Second
+		case 'm':
+			unit = time.Minute
+		}
+		// Large counts would wrap around instead of failing like time.ParseDuration does
+		n, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil || n > int64(math.MaxInt64/unit) {
+			return 0, fmt.Errorf("delay is too long: %q", s)
+		}
+		d = time.Duration(n) * unit
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("delay must be positive: %q", s)
+	}
+	return d, nil
 }
 
-// validateKeepAwakeInput validates keep awake input (true/false)
-func (app *Application) validateKeepAwakeInput(payload string) (bool, error) {
-	keepAwake, err := strconv.ParseBool(payload)
+// parseActionTime parses an RFC 3339 time or a clock time like "23:30", which is the next time the clock shows it
+func parseActionTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if at, err := time.Parse(time.RFC3339, s); err == nil {
+		if !at.After(now) {
+			return time.Time{}, fmt.Errorf("time is in the past: %q", s)
+		}
+		return at, nil
+	}
+
+	clock, err := time.Parse("15:04", s)
 	if err != nil {
-		return false, fmt.Errorf("keep awake must be true or false: %w", err)
+		return time.Time{}, fmt.Errorf("invalid time %q, use 23:30 or RFC 3339", s)
+	}
+	at := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	if !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at, nil
+}
+
+// validateSystemCommandInput validates system command input: an action, optionally followed by "in <delay>" or
+// "at <time>" and "force", or JSON with action, in or at, and force. The returned At is zero for actions to run right
+// away.
+func validateSystemCommandInput(payload string, now time.Time) (*ScheduledAction, error) {
+	payload = strings.TrimSpace(payload)
+	var action, in, at string
+	var force bool
+
+	if strings.HasPrefix(payload, "{") {
+		var body struct {
+			Action string `json:"action"`
+			In     string `json:"in"`
+			At     string `json:"at"`
+			Force  bool   `json:"force"`
+		}
+		if err := json.Unmarshal([]byte(payload), &body); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		action, in, at, force = body.Action, body.In, body.At, body.Force
+	} else {
+		fields := strings.Fields(payload)
+		if len(fields) > 1 && fields[len(fields)-1] == "force" {
+			fields, force = fields[:len(fields)-1], true
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("empty command")
+		}
+		action = fields[0]
+		if len(fields) > 1 {
+			when := strings.Join(fields[2:], " ")
+			switch fields[1] {
+			case "in":
+				in = when
+			case "at":
+				at = when
+			default:
+				return nil, fmt.Errorf("expected \"in\" or \"at\" after %s: %q", action, payload)
+			}
+			// "shutdown in" without a delay must not run right away
+			if when == "" {
+				return nil, fmt.Errorf("expected a delay or time after %s: %q", fields[1], payload)
+			}
+		}
+	}
+
+	if systemActions[action] == nil {
+		return nil, fmt.Errorf("%q", action)
+	}
+	scheduled := &ScheduledAction{Action: action, Force: force}
+	switch {
+	case in != "" && at != "":
+		return nil, fmt.Errorf("use either in or at, not both")
+	case in != "":
+		d, err := parseDelay(in)
+		if err != nil {
+			return nil, err
+		}
+		scheduled.At = now.Add(d)
+	case at != "":
+		t, err := parseActionTime(at, now)
+		if err != nil {
+			return nil, err
+		}
+		scheduled.At = t
 	}
-	return keepAwake, nil
+	return scheduled, nil
 }
 
 func main() {
@@ -0,0 +1,924 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// readFixture returns the contents of a file in testdata
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestParsePmsetBatt(t *testing.T) {
+	tests := []struct {
+		fixture       string
+		powerSource   string
+		percent       int
+		state         string
+		timeRemaining int
+	}{
+		{"pmset_ac_charging.txt", "AC", 86, "charging", 42},
+		{"pmset_battery.txt", "Battery", 64, "discharging", 312},
+		{"pmset_no_estimate.txt", "Battery", 99, "discharging", -1},
+		{"pmset_ac_not_charging.txt", "AC", 80, "not charging", -1},
+		{"pmset_ups.txt", "UPS", -1, "", -1},
+		{"pmset_desktop.txt", "AC", -1, "", -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			info := parsePmsetBatt(readFixture(t, tt.fixture))
+			if info.PowerSource != tt.powerSource {
+				t.Errorf("PowerSource = %q, want %q", info.PowerSource, tt.powerSource)
+			}
+			if info.Percent != tt.percent {
+				t.Errorf("Percent = %d, want %d", info.Percent, tt.percent)
+			}
+			if info.State != tt.state {
+				t.Errorf("State = %q, want %q", info.State, tt.state)
+			}
+			if info.TimeRemaining != tt.timeRemaining {
+				t.Errorf("TimeRemaining = %d, want %d", info.TimeRemaining, tt.timeRemaining)
+			}
+		})
+	}
+}
+
+func TestParseIoregBattery(t *testing.T) {
+	tests := []struct {
+		fixture        string
+		cycleCount     int
+		designCapacity int
+		maxCapacity    int
+		temperature    float64
+		adapterWatts   int
+		condition      string
+	}{
+		// MaxCapacity is a percentage on Apple Silicon, the mAh value comes from AppleRawMaxCapacity
+		{"ioreg_apple_silicon.txt", 87, 4382, 4101, 30.12, 96, "Normal"},
+		// MaxCapacity is in mAh on Intel, 5103 of 6669 mAh is below 80% health
+		{"ioreg_intel.txt", 412, 6669, 5103, 29.81, 0, "Service Recommended"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			info := &BatteryInfo{Percent: 50, TimeRemaining: -1, CycleCount: -1}
+			parseIoregBattery(readFixture(t, tt.fixture), info)
+			if info.CycleCount != tt.cycleCount {
+				t.Errorf("CycleCount = %d, want %d", info.CycleCount, tt.cycleCount)
+			}
+			if info.DesignCapacity != tt.designCapacity {
+				t.Errorf("DesignCapacity = %d, want %d", info.DesignCapacity, tt.designCapacity)
+			}
+			if info.MaxCapacity != tt.maxCapacity {
+				t.Errorf("MaxCapacity = %d, want %d", info.MaxCapacity, tt.maxCapacity)
+			}
+			if info.Temperature != tt.temperature {
+				t.Errorf("Temperature = %v, want %v", info.Temperature, tt.temperature)
+			}
+			if info.AdapterWatts != tt.adapterWatts {
+				t.Errorf("AdapterWatts = %d, want %d", info.AdapterWatts, tt.adapterWatts)
+			}
+			if info.Condition != tt.condition {
+				t.Errorf("Condition = %q, want %q", info.Condition, tt.condition)
+			}
+		})
+	}
+}
+
+// serveDNS answers the first query on a local UDP port with body, adding idOffset to the response ID
+func serveDNS(t *testing.T, body dnsmessage.ResourceBody, idOffset uint16) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var query dnsmessage.Message
+		if err := query.Unpack(buf[:n]); err != nil || len(query.Questions) != 1 {
+			return
+		}
+		question := query.Questions[0]
+		response := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID + idOffset, Response: true},
+			Questions: query.Questions,
+			Answers: []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: question.Name, Type: question.Type, Class: question.Class},
+				Body:   body,
+			}},
+		}
+		packet, err := response.Pack()
+		if err != nil {
+			return
+		}
+		conn.WriteTo(packet, addr)
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestDNSIPProvider(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		body     dnsmessage.ResourceBody
+		idOffset uint16
+		want     string
+		wantErr  bool
+	}{
+		{"txt answer", "google", &dnsmessage.TXTResource{TXT: []string{"203.0.113.7"}}, 0, "203.0.113.7", false},
+		{"a answer", "opendns", &dnsmessage.AResource{A: [4]byte{198, 51, 100, 23}}, 0, "198.51.100.23", false},
+		{"non-IP txt answer", "cloudflare", &dnsmessage.TXTResource{TXT: []string{"edns0-client-subnet 0.0.0.0/0"}}, 0, "", true},
+		{"ID mismatch", "google", &dnsmessage.TXTResource{TXT: []string{"203.0.113.7"}}, 1, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, err := newPublicIPProvider(PublicIPProviderConfig{Type: tt.provider, Server: serveDNS(t, tt.body, tt.idOffset)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			got, err := provider.Lookup(ctx, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Lookup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Lookup() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// stunResponse builds a binding success response with an XOR-MAPPED-ADDRESS attribute for ip
+func stunResponse(transactionID []byte, ip net.IP) []byte {
+	family, addr := byte(0x01), ip.To4()
+	if addr == nil {
+		family, addr = 0x02, ip.To16()
+	}
+	key := append(binary.BigEndian.AppendUint32(nil, stunMagicCookie), transactionID...)
+	value := []byte{0, family, 0x11, 0x2b}
+	for i, b := range addr {
+		value = append(value, b^key[i])
+	}
+
+	attr := binary.BigEndian.AppendUint16(nil, 0x0020)
+	attr = binary.BigEndian.AppendUint16(attr, uint16(len(value)))
+	attr = append(attr, value...)
+
+	response := binary.BigEndian.AppendUint16(nil, 0x0101)
+	response = binary.BigEndian.AppendUint16(response, uint16(len(attr)))
+	response = binary.BigEndian.AppendUint32(response, stunMagicCookie)
+	response = append(response, transactionID...)
+	return append(response, attr...)
+}
+
+func TestParseSTUNResponse(t *testing.T) {
+	transactionID := []byte("0123456789ab")
+	otherID := []byte("ba9876543210")
+
+	tests := []struct {
+		name     string
+		response []byte
+		want     string
+		wantErr  bool
+	}{
+		{"xor mapped IPv4", stunResponse(transactionID, net.ParseIP("203.0.113.7")), "203.0.113.7", false},
+		{"xor mapped IPv6", stunResponse(transactionID, net.ParseIP("2001:db8::1234")), "2001:db8::1234", false},
+		{"transaction ID mismatch", stunResponse(otherID, net.ParseIP("203.0.113.7")), "", true},
+		{"short response", []byte{0x01, 0x01, 0x00}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSTUNResponse(tt.response, transactionID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSTUNResponse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseSTUNResponse() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePublicIP(t *testing.T) {
+	tests := []struct {
+		body    string
+		ipv6    bool
+		want    string
+		wantErr bool
+	}{
+		{"203.0.113.7", false, "203.0.113.7", false},
+		{"2001:db8::1", true, "2001:db8::1", false},
+		{"2001:db8::1", false, "", true},
+		{"203.0.113.7", true, "", true},
+		{"<html><body>Too Many Requests</body></html>", false, "", true},
+		{"", false, "", true},
+		{"203.0.113", false, "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := parsePublicIP(tt.body, tt.ipv6)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePublicIP(%q, %v) error = %v, wantErr %v", tt.body, tt.ipv6, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("parsePublicIP(%q, %v) = %q, want %q", tt.body, tt.ipv6, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPSIPProviderRejectsNonIPBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html>rate limited</html>")
+	}))
+	defer server.Close()
+
+	provider, err := newPublicIPProvider(PublicIPProviderConfig{Type: "https", Server: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip, err := provider.Lookup(context.Background(), false); err == nil {
+		t.Errorf("Lookup() = %q, want an error", ip)
+	}
+}
+
+// float64Ptr returns a pointer to v, for expected optional readings
+func float64Ptr(v float64) *float64 {
+	return &v
+}
+
+// checkReading compares an optional reading, nil meaning the reading is missing
+func checkReading(t *testing.T, name string, got, want *float64) {
+	t.Helper()
+	switch {
+	case got == nil && want == nil:
+	case got == nil || want == nil:
+		t.Errorf("%s = %v, want %v", name, got, want)
+	case *got != *want:
+		t.Errorf("%s = %v, want %v", name, *got, *want)
+	}
+}
+
+func TestParsePowermetricsThermal(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		pressure string
+		cpu      *float64
+		gpu      *float64
+		fans     []float64
+	}{
+		{"powermetrics_intel.txt", "moderate", float64Ptr(71.25), float64Ptr(58), []float64{2157.61}},
+		// Apple Silicon has no smc sampler, only the thermal pressure is reported
+		{"powermetrics_apple_silicon.txt", "nominal", nil, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			info := parsePowermetricsThermal(readFixture(t, tt.fixture))
+			if info.Pressure != tt.pressure {
+				t.Errorf("Pressure = %q, want %q", info.Pressure, tt.pressure)
+			}
+			checkReading(t, "CPUTemperature", info.CPUTemperature, tt.cpu)
+			checkReading(t, "GPUTemperature", info.GPUTemperature, tt.gpu)
+			if fmt.Sprint(info.Fans) != fmt.Sprint(tt.fans) {
+				t.Errorf("Fans = %v, want %v", info.Fans, tt.fans)
+			}
+		})
+	}
+}
+
+func TestThermalInfoFromSMC(t *testing.T) {
+	tests := []struct {
+		fixture string
+		cpu     *float64
+		gpu     *float64
+		fans    []float64
+	}{
+		// Intel keys are looked up by name, the malformed MSSD line is skipped
+		{"smc_intel.txt", float64Ptr(68.25), float64Ptr(57.75), []float64{2157, 2093}},
+		// Apple Silicon reports the hottest Tp key, ignoring idle zero readings, and has no GPU keys
+		{"smc_apple_silicon.txt", float64Ptr(52.75), nil, []float64{1203.5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			info := thermalInfoFromSMC(parseSMCKeys(readFixture(t, tt.fixture)))
+			if info.Pressure != "" {
+				t.Errorf("Pressure = %q, want none from smc", info.Pressure)
+			}
+			checkReading(t, "CPUTemperature", info.CPUTemperature, tt.cpu)
+			checkReading(t, "GPUTemperature", info.GPUTemperature, tt.gpu)
+			if fmt.Sprint(info.Fans) != fmt.Sprint(tt.fans) {
+				t.Errorf("Fans = %v, want %v", info.Fans, tt.fans)
+			}
+		})
+	}
+
+	t.Run("no keys", func(t *testing.T) {
+		info := thermalInfoFromSMC(parseSMCKeys(""))
+		if info.CPUTemperature != nil || info.GPUTemperature != nil || len(info.Fans) != 0 {
+			t.Errorf("thermalInfoFromSMC() = %+v, want no readings", info)
+		}
+	})
+}
+
+// fakeSwitchAudioSource writes a SwitchAudioSource stand-in that reports device as the current output
+func fakeSwitchAudioSource(t *testing.T, device string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "SwitchAudioSource")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\necho '"+device+"'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newTestBetterDisplayBackend returns a BetterDisplay backend for baseURL with a short timeout
+func newTestBetterDisplayBackend(t *testing.T, baseURL string) *betterDisplayAudioBackend {
+	timeout := 200 * time.Millisecond
+	return &betterDisplayAudioBackend{
+		baseURL:               baseURL,
+		switchAudioSourcePath: fakeSwitchAudioSource(t, "LG HDR 4K"),
+		client:                &http.Client{Timeout: timeout},
+		timeout:               time.Second,
+	}
+}
+
+func TestBetterDisplayAudioBackend(t *testing.T) {
+	var gotPath, gotQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotQuery = r.URL.Path, r.URL.RawQuery
+		fmt.Fprint(w, "0.35\n")
+	}))
+	defer server.Close()
+
+	backend := newTestBetterDisplayBackend(t, server.URL+"/")
+	volume, err := backend.GetVolume()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if volume != 35 {
+		t.Errorf("GetVolume() = %d, want 35", volume)
+	}
+	if gotPath != "/get" || gotQuery != "name=LG%20HDR%204K&volume" {
+		t.Errorf("request = %s?%s, want /get?name=LG%%20HDR%%204K&volume", gotPath, gotQuery)
+	}
+
+	if err := backend.SetVolume(60); err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != "/set" || gotQuery != "name=LG%20HDR%204K&volume=0.60" {
+		t.Errorf("request = %s?%s, want /set?name=LG%%20HDR%%204K&volume=0.60", gotPath, gotQuery)
+	}
+}
+
+func TestBetterDisplayAudioBackendErrors(t *testing.T) {
+	notFound := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "display not found", http.StatusNotFound)
+	}))
+	defer notFound.Close()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	refused := httptest.NewServer(http.NotFoundHandler())
+	refused.Close()
+
+	tests := []struct {
+		name        string
+		url         string
+		unavailable bool
+	}{
+		{"non-200 response", notFound.URL, false},
+		{"timeout", slow.URL, true},
+		{"connection refused", refused.URL, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestBetterDisplayBackend(t, tt.url).GetVolume()
+			if err == nil {
+				t.Fatal("GetVolume() succeeded, want an error")
+			}
+			if errors.Is(err, errAudioUnavailable) != tt.unavailable {
+				t.Errorf("GetVolume() error = %v, want errAudioUnavailable %v", err, tt.unavailable)
+			}
+		})
+	}
+}
+
+// fakeAudioBackend is an audio backend with a fixed volume
+type fakeAudioBackend struct {
+	volume int
+}
+
+func (b *fakeAudioBackend) Name() string               { return "fake" }
+func (b *fakeAudioBackend) GetVolume() (int, error)    { return b.volume, nil }
+func (b *fakeAudioBackend) SetVolume(volume int) error { b.volume = volume; return nil }
+func (b *fakeAudioBackend) GetMute() (bool, error)     { return false, nil }
+func (b *fakeAudioBackend) SetMute(mute bool) error    { return nil }
+
+func TestFallbackAudioBackendSkipsUnreachableBetterDisplay(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	backend := &fallbackAudioBackend{backends: []AudioBackend{
+		newTestBetterDisplayBackend(t, server.URL),
+		&fakeAudioBackend{volume: 42},
+	}}
+	volume, err := backend.GetVolume()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if volume != 42 {
+		t.Errorf("GetVolume() = %d, want 42 from the next backend", volume)
+	}
+}
+
+func TestParseAnnouncement(t *testing.T) {
+	tests := []struct {
+		payload string
+		sound   bool
+		want    Announcement
+		wantErr bool
+	}{
+		{payload: "Dinner is ready", want: Announcement{Text: "Dinner is ready"}},
+		{payload: `{"text": "Dinner is ready", "duck": true}`, want: Announcement{Text: "Dinner is ready", Duck: true}},
+		{payload: `{"text": "Dinner is ready", "sound": "Glass"}`, want: Announcement{Text: "Dinner is ready"}},
+		{payload: " Glass ", sound: true, want: Announcement{Sound: "Glass"}},
+		{payload: "   ", wantErr: true},
+		{payload: "../Glass", sound: true, wantErr: true},
+		{payload: `{"text": "hi", "volume": 101}`, wantErr: true},
+		{payload: `{"text": "hi", "rate": -1}`, wantErr: true},
+		{payload: `{"text": `, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseAnnouncement(tt.payload, tt.sound)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseAnnouncement(%q) = %+v, want error", tt.payload, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseAnnouncement(%q) error: %v", tt.payload, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("parseAnnouncement(%q) = %+v, want %+v", tt.payload, *got, tt.want)
+		}
+	}
+}
+
+func TestNonEmptyLines(t *testing.T) {
+	got := nonEmptyLines("MacBook Pro Speakers\n\n  External Headphones  \r\n   \nLiving Room\n")
+	want := []string{"MacBook Pro Speakers", "External Headphones", "Living Room"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("nonEmptyLines() = %q, want %q", got, want)
+	}
+	if got := nonEmptyLines("\n \n"); got != nil {
+		t.Errorf("nonEmptyLines(blank) = %q, want nil", got)
+	}
+}
+
+func TestCustomCommandArgs(t *testing.T) {
+	app := &Application{config: &config{Commands: []CustomCommand{{
+		Name:    "backup",
+		Argv:    []string{"/usr/local/bin/backup.sh", "--target", "{{target}}", "{{tag}}"},
+		Timeout: 60,
+		Params: []CustomParam{
+			{Name: "target", Default: "home"},
+			{Name: "tag", Optional: true},
+		},
+	}}}}
+	if err := app.validateCustomCommands(); err != nil {
+		t.Fatal(err)
+	}
+	command := app.config.Commands[0]
+
+	tests := []struct {
+		payload string
+		want    []string
+		wantErr bool
+	}{
+		{payload: "", want: []string{"/usr/local/bin/backup.sh", "--target", "home", ""}},
+		{payload: `{"target": "documents", "tag": "v1.2"}`, want: []string{"/usr/local/bin/backup.sh", "--target", "documents", "v1.2"}},
+		{payload: `{"tag": ""}`, want: []string{"/usr/local/bin/backup.sh", "--target", "home", ""}},
+		{payload: `{"target": "--delete"}`, wantErr: true},
+		{payload: `{"target": "-rf"}`, wantErr: true},
+		{payload: `{"target": ""}`, wantErr: true},
+		{payload: `{"target": "a b"}`, wantErr: true},
+		{payload: `{"other": "x"}`, wantErr: true},
+		{payload: `["home"]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := customCommandArgs(command, tt.payload)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("customCommandArgs(%s) = %q, want error", tt.payload, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("customCommandArgs(%s) error: %v", tt.payload, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("customCommandArgs(%s) = %q, want %q", tt.payload, got, tt.want)
+		}
+	}
+}
+
+func TestParseDelay(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "30m", want: 30 * time.Minute},
+		{in: "1h30m", want: 90 * time.Minute},
+		{in: "30 minutes", want: 30 * time.Minute},
+		{in: " 1 Hour ", want: time.Hour},
+		{in: "45secs", want: 45 * time.Second},
+		{in: "2 hrs", want: 2 * time.Hour},
+		{in: "0m", wantErr: true},
+		{in: "-5m", wantErr: true},
+		{in: "0 minutes", wantErr: true},
+		{in: "soon", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "9999999999 hours", wantErr: true},
+		{in: "99999999999999999999 seconds", wantErr: true},
+		{in: "2562047 hours", want: 2562047 * time.Hour},
+		{in: "2562048 hours", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseDelay(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseDelay(%q) = %v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseDelay(%q) error: %v", tt.in, err)
+		} else if got != tt.want {
+			t.Errorf("parseDelay(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseActionTime(t *testing.T) {
+	now := time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "23:30", want: time.Date(2024, 5, 1, 23, 30, 0, 0, time.UTC)},
+		{in: "07:00", want: time.Date(2024, 5, 2, 7, 0, 0, 0, time.UTC)},
+		{in: "18:00", want: time.Date(2024, 5, 2, 18, 0, 0, 0, time.UTC)},
+		{in: "2024-05-03T09:15:00Z", want: time.Date(2024, 5, 3, 9, 15, 0, 0, time.UTC)},
+		{in: "2024-05-01T17:00:00Z", wantErr: true},
+		{in: "25:00", wantErr: true},
+		{in: "tonight", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseActionTime(tt.in, now)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseActionTime(%q) = %v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseActionTime(%q) error: %v", tt.in, err)
+		} else if !got.Equal(tt.want) {
+			t.Errorf("parseActionTime(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestValidateSystemCommandInput(t *testing.T) {
+	now := time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC)
+	tests := []struct {
+		payload string
+		want    ScheduledAction
+		wantErr bool
+	}{
+		{payload: "sleep", want: ScheduledAction{Action: "sleep"}},
+		{payload: "shutdown force", want: ScheduledAction{Action: "shutdown", Force: true}},
+		{payload: "sleep in 30 minutes", want: ScheduledAction{Action: "sleep", At: now.Add(30 * time.Minute)}},
+		{payload: "shutdown in 1h30m force", want: ScheduledAction{Action: "shutdown", At: now.Add(90 * time.Minute), Force: true}},
+		{payload: "displaysleep at 23:30", want: ScheduledAction{Action: "displaysleep", At: time.Date(2024, 5, 1, 23, 30, 0, 0, time.UTC)}},
+		{payload: `{"action": "restart", "in": "10m", "force": true}`, want: ScheduledAction{Action: "restart", At: now.Add(10 * time.Minute), Force: true}},
+		{payload: "shutdown in", wantErr: true},
+		{payload: "sleep at", wantErr: true},
+		{payload: "shutdown in force", wantErr: true},
+		{payload: "shutdown in 9999999999 hours", wantErr: true},
+		{payload: "shutdown now", wantErr: true},
+		{payload: "format in 5m", wantErr: true},
+		{payload: "", wantErr: true},
+		{payload: "force", wantErr: true},
+		{payload: `{"action": "sleep", "in": "5m", "at": "23:30"}`, wantErr: true},
+		{payload: `{"action": "sleep"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := validateSystemCommandInput(tt.payload, now)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("validateSystemCommandInput(%q) = %+v, want error", tt.payload, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("validateSystemCommandInput(%q) error: %v", tt.payload, err)
+			continue
+		}
+		if got.Action != tt.want.Action || !got.At.Equal(tt.want.At) || got.Force != tt.want.Force {
+			t.Errorf("validateSystemCommandInput(%q) = %+v, want %+v", tt.payload, *got, tt.want)
+		}
+	}
+}
+
+func TestParseNotification(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    Notification
+		wantErr bool
+	}{
+		{payload: "The washer is done", want: Notification{Title: "mac2mqtt", Message: "The washer is done"}},
+		{payload: `{"title": "Laundry", "message": "Done", "timeout": 300}`, want: Notification{Title: "Laundry", Message: "Done", Timeout: 300}},
+		{payload: `{"message": "Done", "timeout": 86400}`, want: Notification{Title: "mac2mqtt", Message: "Done", Timeout: 86400}},
+		{payload: `{"message": "Done", "timeout": 86401}`, wantErr: true},
+		{payload: `{"message": "Done", "timeout": 9223372037}`, wantErr: true},
+		{payload: `{"message": "Done", "timeout": -1}`, wantErr: true},
+		{payload: `{"message": "Done", "actions": ["Yes, please"]}`, wantErr: true},
+		{payload: `{"title": "Laundry"}`, wantErr: true},
+		{payload: " ", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseNotification(tt.payload)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseNotification(%q) = %+v, want error", tt.payload, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseNotification(%q) error: %v", tt.payload, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("parseNotification(%q) = %+v, want %+v", tt.payload, *got, tt.want)
+		}
+	}
+}
+
+func TestValidateCustomCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []CustomCommand
+		wantErr  bool
+	}{
+		{name: "valid", commands: []CustomCommand{{Name: "backup", Argv: []string{"/bin/echo"}, Timeout: 60}}},
+		{name: "result suffix", commands: []CustomCommand{{Name: "backup_result", Argv: []string{"/bin/echo"}, Timeout: 60}}, wantErr: true},
+		{name: "negative timeout", commands: []CustomCommand{{Name: "backup", Argv: []string{"/bin/echo"}, Timeout: -1}}, wantErr: true},
+		{name: "relative argv", commands: []CustomCommand{{Name: "backup", Argv: []string{"echo"}, Timeout: 60}}, wantErr: true},
+		{name: "unknown placeholder", commands: []CustomCommand{{Name: "backup", Argv: []string{"/bin/echo", "{{x}}"}, Timeout: 60}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		app := &Application{config: &config{Commands: tt.commands}}
+		if err := app.validateCustomCommands(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateCustomCommands() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestExtractSensorValue(t *testing.T) {
+	const docker = `{"containers": [{"name": "web", "cpu": 2.5, "up": true}, {"name": "db", "cpu": -2.5, "up": false}], "note": null}`
+	tests := []struct {
+		name     string
+		regex    string
+		jsonPath string
+		typ      string
+		output   string
+		want     string
+		wantErr  bool
+	}{
+		{name: "whole output", output: "  hello world \n", want: "hello world"},
+		{name: "regex capture group", regex: `temp=(\d+)`, typ: "int", output: "temp=42C", want: "42"},
+		{name: "regex whole match", regex: `\d+\.\d+`, typ: "float", output: "load 1.50 2.00", want: "1.5"},
+		{name: "regex no match", regex: `temp=(\d+)`, output: "fan=1200", wantErr: true},
+		{name: "json string", jsonPath: "containers.1.name", output: docker, want: "db"},
+		{name: "json int rounds half away from zero", jsonPath: "containers.0.cpu", typ: "int", output: docker, want: "3"},
+		{name: "json negative int", jsonPath: "containers.1.cpu", typ: "int", output: docker, want: "-3"},
+		{name: "json bool", jsonPath: "containers.0.up", typ: "bool", output: docker, want: "ON"},
+		{name: "json bool false", jsonPath: "containers.1.up", typ: "bool", output: docker, want: "OFF"},
+		{name: "json index out of range", jsonPath: "containers.2.name", output: docker, wantErr: true},
+		{name: "json negative index", jsonPath: "containers.-1.name", output: docker, wantErr: true},
+		{name: "json index not a number", jsonPath: "containers.first.name", output: docker, wantErr: true},
+		{name: "json missing key", jsonPath: "images", output: docker, wantErr: true},
+		{name: "json key in a scalar", jsonPath: "containers.0.name.first", output: docker, wantErr: true},
+		{name: "json array", jsonPath: "containers", output: docker, wantErr: true},
+		{name: "json object", jsonPath: "containers.0", output: docker, wantErr: true},
+		{name: "json null", jsonPath: "note", output: docker, wantErr: true},
+		{name: "invalid json", jsonPath: "a", output: "not json", wantErr: true},
+		{name: "bool words", typ: "bool", output: "Yes\n", want: "ON"},
+		{name: "bool zero", typ: "bool", output: "0", want: "OFF"},
+		{name: "not a bool", typ: "bool", output: "maybe", wantErr: true},
+		{name: "not a number", typ: "int", output: "n/a", wantErr: true},
+		{name: "float", typ: "float", output: "3.250", want: "3.25"},
+	}
+	for _, tt := range tests {
+		sensor := CustomSensor{Name: "test", JSONPath: tt.jsonPath, Type: tt.typ}
+		if tt.regex != "" {
+			sensor.re = regexp.MustCompile(tt.regex)
+		}
+		got, err := extractSensorValue(sensor, tt.output)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: extractSensorValue() = %q, want error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: extractSensorValue() error: %v", tt.name, err)
+		} else if got != tt.want {
+			t.Errorf("%s: extractSensorValue() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// openTestTree creates dir/a/b/file.txt, dir/a/bc/file.txt and symlinks in dir/a/b pointing inside and outside it
+func openTestTree(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, sub := range []string{"a/b", "a/bc"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, sub, "file.txt"), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "a/bc/file.txt"), filepath.Join(dir, "a/b/escape.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "a/b/file.txt"), filepath.Join(dir, "a/b/inside.txt")); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestResolveOpenPath(t *testing.T) {
+	dir := openTestTree(t)
+	root := filepath.Join(dir, "a/b")
+	file, err := filepath.EvalSymlinks(filepath.Join(root, "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		roots   []string
+		want    string
+		wantErr bool
+	}{
+		{name: "file under root", path: filepath.Join(root, "file.txt"), roots: []string{root}, want: file},
+		{name: "root itself", path: root, roots: []string{root}, want: filepath.Dir(file)},
+		{name: "symlink inside root", path: filepath.Join(root, "inside.txt"), roots: []string{root}, want: file},
+		{name: "second root", path: filepath.Join(root, "file.txt"), roots: []string{filepath.Join(dir, "missing"), root}, want: file},
+		{name: "symlink escaping root", path: filepath.Join(root, "escape.txt"), roots: []string{root}, wantErr: true},
+		{name: "dot dot traversal", path: root + "/../bc/file.txt", roots: []string{root}, wantErr: true},
+		{name: "sibling with root as prefix", path: filepath.Join(dir, "a/bc/file.txt"), roots: []string{root}, wantErr: true},
+		{name: "parent of root", path: filepath.Join(dir, "a"), roots: []string{root}, wantErr: true},
+		{name: "missing file", path: filepath.Join(root, "missing.txt"), roots: []string{root}, wantErr: true},
+		{name: "no roots", path: filepath.Join(root, "file.txt"), wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := resolveOpenPath(tt.path, tt.roots)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: resolveOpenPath(%q) = %q, want error", tt.name, tt.path, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: resolveOpenPath(%q) error: %v", tt.name, tt.path, err)
+		} else if got != tt.want {
+			t.Errorf("%s: resolveOpenPath(%q) = %q, want %q", tt.name, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestValidateOpenInput(t *testing.T) {
+	dir := openTestTree(t)
+	root := filepath.Join(dir, "a/b")
+	file, err := filepath.EvalSymlinks(filepath.Join(root, "file.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &Application{config: &config{Open: OpenConfig{Schemes: []string{"http", "https"}, Roots: []string{root}}}}
+
+	tests := []struct {
+		payload string
+		want    OpenTarget
+		wantErr bool
+	}{
+		{payload: "https://example.com/page", want: OpenTarget{URL: "https://example.com/page"}},
+		{payload: `{"url": "HTTP://example.com"}`, want: OpenTarget{URL: "HTTP://example.com"}},
+		{payload: "Safari", want: OpenTarget{App: "Safari"}},
+		{payload: "Visual Studio Code", want: OpenTarget{App: "Visual Studio Code"}},
+		{payload: `{"bundle_id": "com.apple.Safari"}`, want: OpenTarget{BundleID: "com.apple.Safari"}},
+		{payload: filepath.Join(root, "file.txt"), want: OpenTarget{Path: file}},
+		{payload: "ftp://example.com", wantErr: true},
+		{payload: "file:///etc/passwd", wantErr: true},
+		{payload: "javascript:alert(1)", wantErr: true},
+		{payload: filepath.Join(dir, "a/bc/file.txt"), wantErr: true},
+		{payload: filepath.Join(root, "escape.txt"), wantErr: true},
+		{payload: "-a", wantErr: true},
+		{payload: `{"app": "-n"}`, wantErr: true},
+		{payload: `{"bundle_id": "-b.apple.Safari"}`, wantErr: true},
+		{payload: `{"bundle_id": "Safari"}`, wantErr: true},
+		{payload: `{"url": "https://example.com", "app": "Safari"}`, wantErr: true},
+		{payload: "{}", wantErr: true},
+		{payload: " ", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := app.validateOpenInput(tt.payload)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("validateOpenInput(%q) = %+v, want error", tt.payload, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("validateOpenInput(%q) error: %v", tt.payload, err)
+		} else if *got != tt.want {
+			t.Errorf("validateOpenInput(%q) = %+v, want %+v", tt.payload, *got, tt.want)
+		}
+	}
+
+	noRoots := &Application{config: &config{Open: OpenConfig{Schemes: []string{"https"}}}}
+	if got, err := noRoots.validateOpenInput(filepath.Join(root, "file.txt")); err == nil {
+		t.Errorf("validateOpenInput() without roots = %+v, want error", got)
+	}
+}
+
+func TestLogAudioErrorOnlyOnChange(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	app := &Application{audioBackend: &fakeAudioBackend{}}
+	unsupported := fmt.Errorf("no volume control: %w", errAudioUnsupported)
+	for _, err := range []error{unsupported, unsupported, unsupported, errors.New("timeout"), errors.New("timeout"), nil, nil, unsupported} {
+		app.logAudioError("volume", err)
+	}
+
+	lines := nonEmptyLines(buf.String())
+	if len(lines) != 4 {
+		t.Fatalf("logged %d lines, want 4 (failure, changed failure, recovery, failure):\n%s", len(lines), buf.String())
+	}
+	if !strings.Contains(lines[2], "works again") {
+		t.Errorf("third line = %q, want the recovery", lines[2])
+	}
+}